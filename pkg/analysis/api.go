@@ -0,0 +1,177 @@
+package analysis
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// defaultDockerHost is the docker daemon socket used when DOCKER_HOST isn't set.
+const defaultDockerHost = "unix:///var/run/docker.sock"
+
+// HistoryItem is one entry of an image's history as reported by the Engine API, newest layer first.
+type HistoryItem struct {
+	ID        string
+	Created   int64 // Unix time in seconds
+	CreatedBy string
+	Tags      []string
+	Size      int64 // in bytes
+	Comment   string
+}
+
+// EngineAPI is the part of the docker Engine API used to load images without the docker CLI.
+// APIClient implements it over the daemon socket. The method names follow the official Go SDK,
+// so code that already holds an SDK client can satisfy it with a thin adapter.
+type EngineAPI interface {
+	ImageHistory(ctx context.Context, name string) ([]HistoryItem, error)
+	ImageInspectWithRaw(ctx context.Context, name string) (ImageInspect, []byte, error)
+}
+
+// imageInspector is implemented by the clients an image can be loaded with.
+type imageInspector interface {
+	Inspect(ctx context.Context, name string) (*ImageInspect, error)
+	InspectRaw(ctx context.Context, name string) ([]byte, error)
+}
+
+// APIClient talks to the docker Engine API directly, for environments where the daemon
+// socket is available but the docker CLI isn't.
+type APIClient struct {
+	httpClient *http.Client
+	baseURL    string
+}
+
+// NewAPIClient creates an Engine API client for a DOCKER_HOST style address, either
+// unix:///path/to/docker.sock or tcp://host:port. An empty host uses the DOCKER_HOST
+// environment variable, falling back to the default socket. TLS is not supported.
+func NewAPIClient(host string) (*APIClient, error) {
+	if host == "" {
+		host = os.Getenv("DOCKER_HOST")
+	}
+	if host == "" {
+		host = defaultDockerHost
+	}
+
+	u, err := url.Parse(host)
+	if err != nil {
+		return nil, fmt.Errorf("invalid docker host %q: %w", host, err)
+	}
+	switch u.Scheme {
+	case "unix":
+		socket := u.Path
+		transport := &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var dialer net.Dialer
+				return dialer.DialContext(ctx, "unix", socket)
+			},
+		}
+		return &APIClient{httpClient: &http.Client{Transport: transport}, baseURL: "http://docker"}, nil
+	case "tcp", "http":
+		return &APIClient{httpClient: &http.Client{}, baseURL: "http://" + u.Host}, nil
+	default:
+		return nil, fmt.Errorf("unsupported docker host %q", host)
+	}
+}
+
+// get performs a GET request against the Engine API and returns the response body.
+// A 404 response returns an error wrapping ErrImageNotFound.
+func (c *APIClient) get(ctx context.Context, path string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.baseURL+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		var apiErr struct {
+			Message string
+		}
+		message := strings.TrimSpace(string(body))
+		if json.Unmarshal(body, &apiErr) == nil && apiErr.Message != "" {
+			message = apiErr.Message
+		}
+		if resp.StatusCode == http.StatusNotFound {
+			return nil, fmt.Errorf("%w: %s", ErrImageNotFound, message)
+		}
+		return nil, fmt.Errorf("docker API returned %s: %s", resp.Status, message)
+	}
+	return body, nil
+}
+
+// ImageHistory returns the history of an image, newest layer first.
+func (c *APIClient) ImageHistory(ctx context.Context, name string) ([]HistoryItem, error) {
+	body, err := c.get(ctx, "/images/"+url.PathEscape(name)+"/history")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get image history: %w", err)
+	}
+	var items []HistoryItem
+	if err := json.Unmarshal(body, &items); err != nil {
+		return nil, fmt.Errorf("failed to parse image history: %w", err)
+	}
+	return items, nil
+}
+
+// ImageInspectWithRaw returns the inspect information of an image along with the raw JSON it was parsed from.
+func (c *APIClient) ImageInspectWithRaw(ctx context.Context, name string) (ImageInspect, []byte, error) {
+	body, err := c.get(ctx, "/images/"+url.PathEscape(name)+"/json")
+	if err != nil {
+		return ImageInspect{}, nil, fmt.Errorf("failed to inspect image: %w", err)
+	}
+	var inspect ImageInspect
+	if err := json.Unmarshal(body, &inspect); err != nil {
+		return ImageInspect{}, nil, fmt.Errorf("failed to parse inspect output: %w", err)
+	}
+	return inspect, body, nil
+}
+
+// Inspect gets detailed information about an image.
+func (c *APIClient) Inspect(ctx context.Context, name string) (*ImageInspect, error) {
+	inspect, _, err := c.ImageInspectWithRaw(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	return &inspect, nil
+}
+
+// InspectRaw returns the inspect JSON of an image, wrapped in an array like `docker image inspect` prints it.
+func (c *APIClient) InspectRaw(ctx context.Context, name string) ([]byte, error) {
+	_, raw, err := c.ImageInspectWithRaw(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	return append(append([]byte("["), raw...), ']'), nil
+}
+
+// NewDockerImageFromAPI builds a DockerImage for a local image through the Engine API.
+// Layers, sizes and parent links are assembled exactly as the docker CLI loaders do,
+// and layers also carry the tags the daemon reports for them.
+// It returns an error wrapping ErrImageNotFound if the image doesn't exist.
+func NewDockerImageFromAPI(ctx context.Context, api EngineAPI, name string) (*DockerImage, error) {
+	if _, _, err := api.ImageInspectWithRaw(ctx, name); err != nil {
+		return nil, err
+	}
+	items, err := api.ImageHistory(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+
+	image := newDockerImageFromHistoryItems(items)
+	image.Name = name
+	if inspector, ok := api.(imageInspector); ok {
+		image.client = inspector
+	}
+	return image, nil
+}
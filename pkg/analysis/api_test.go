@@ -0,0 +1,107 @@
+package analysis
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// fakeEngineAPI serves the Engine API endpoints for one image from the testdata fixtures. It
+// matches the escaped request path, so that names which aren't escaped aren't found.
+func fakeEngineAPI(t *testing.T, name string) *APIClient {
+	t.Helper()
+	history, err := os.ReadFile(filepath.Join("testdata", "history_api.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(filepath.Join("testdata", "inspect.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// docker image inspect prints an array; the Engine API returns the image alone.
+	var inspect []json.RawMessage
+	if err := json.Unmarshal(data, &inspect); err != nil {
+		t.Fatal(err)
+	}
+
+	prefix := "/images/" + strings.ReplaceAll(name, "/", "%2F")
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.EscapedPath() {
+		case prefix + "/history":
+			w.Write(history)
+		case prefix + "/json":
+			w.Write(inspect[0])
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"message":"No such image: ` + r.URL.Path + `"}`))
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	api, err := NewAPIClient("tcp://" + strings.TrimPrefix(server.URL, "http://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return api
+}
+
+func TestNewDockerImageFromAPIMatchesCLI(t *testing.T) {
+	const name = "registry.local:5000/team/app:1.4"
+	api := fakeEngineAPI(t, name)
+	fromAPI, err := NewDockerImageFromAPI(context.Background(), api, name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	docker := &fakeDocker{t: t, fixtures: map[string]string{
+		"image inspect": "inspect.json",
+		"history":       "history.jsonl",
+	}}
+	fromCLI, err := docker.client().NewDockerImage(context.Background(), name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if fromAPI.Name != fromCLI.Name || fromAPI.Size != fromCLI.Size || len(fromAPI.Layers) != len(fromCLI.Layers) {
+		t.Fatalf("got %q of %d bytes with %d layers, want %q of %d bytes with %d layers",
+			fromAPI.Name, fromAPI.Size, len(fromAPI.Layers), fromCLI.Name, fromCLI.Size, len(fromCLI.Layers))
+	}
+	for i, got := range fromAPI.Layers {
+		want := fromCLI.Layers[i]
+		if got.ID != want.ID || got.Size != want.Size || got.Command != want.Command || !got.Created.Equal(want.Created) ||
+			got.CreatedBy != want.CreatedBy || got.Comment != want.Comment {
+			t.Errorf("layer %d: got %+v, want %+v", i, got, want)
+		}
+		if i == 0 && got.Parent != nil || i > 0 && got.Parent != &fromAPI.Layers[i-1] {
+			t.Errorf("layer %d: wrong parent", i)
+		}
+	}
+	// Only the Engine API reports the tags of each layer.
+	if tags := fromAPI.Layers[4].Tags; len(tags) != 1 || tags[0] != "app:1.4" {
+		t.Errorf("top layer Tags = %q, want [app:1.4]", tags)
+	}
+
+	if fromAPI.client != api {
+		t.Error("image isn't attached to the API client that loaded it")
+	}
+	inspect, err := fromAPI.InspectContext(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if inspect.Config.User != "app" {
+		t.Errorf("Inspect returned %+v", inspect)
+	}
+}
+
+func TestNewDockerImageFromAPIMissingImage(t *testing.T) {
+	api := fakeEngineAPI(t, "app:1.4")
+	_, err := NewDockerImageFromAPI(context.Background(), api, "app:2")
+	if !errors.Is(err, ErrImageNotFound) || !strings.Contains(err.Error(), "No such image") {
+		t.Errorf("got error %v, want ErrImageNotFound with the daemon's message", err)
+	}
+}
@@ -0,0 +1,312 @@
+package analysis
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// saveManifest is an entry of the manifest.json written by `docker save`.
+type saveManifest struct {
+	Config   string
+	RepoTags []string
+	Layers   []string
+}
+
+// ociDescriptor points to a blob in an OCI layout or a registry.
+type ociDescriptor struct {
+	MediaType   string            `json:"mediaType"`
+	Digest      string            `json:"digest"`
+	Size        int64             `json:"size"`
+	Platform    *ociPlatform      `json:"platform,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// ociPlatform is the platform of an image in an image index or manifest list.
+type ociPlatform struct {
+	Architecture string `json:"architecture"`
+	OS           string `json:"os"`
+	Variant      string `json:"variant,omitempty"`
+}
+
+// String returns the platform as "os/arch" or "os/arch/variant".
+func (p ociPlatform) String() string {
+	if p.Variant == "" {
+		return p.OS + "/" + p.Architecture
+	}
+	return p.OS + "/" + p.Architecture + "/" + p.Variant
+}
+
+// ociManifest holds the fields shared by OCI/docker image manifests and image indexes.
+type ociManifest struct {
+	MediaType string          `json:"mediaType"`
+	Config    ociDescriptor   `json:"config"`
+	Layers    []ociDescriptor `json:"layers"`
+	Manifests []ociDescriptor `json:"manifests"`
+}
+
+// layerFile is an entry of a layer tarball.
+type layerFile struct {
+	Path string
+	Size int64
+	// Whiteout marks an entry that deletes Path from the layers below.
+	Whiteout bool
+	// Opaque marks a whiteout that hides everything under the directory Path from the layers below.
+	Opaque bool
+}
+
+// walkTar calls fn for every entry of a tar stream.
+func walkTar(r io.Reader, fn func(header *tar.Header, r io.Reader) error) error {
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read tar: %w", err)
+		}
+		if err := fn(header, tr); err != nil {
+			return err
+		}
+	}
+}
+
+// decompress returns a reader for a layer blob, transparently handling gzip compression.
+func decompress(r io.Reader) (io.Reader, error) {
+	br := bufio.NewReader(r)
+	magic, err := br.Peek(2)
+	if err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		return gzip.NewReader(br)
+	}
+	return br, nil
+}
+
+// cleanPath normalizes a path from a tarball to a slash-separated path without a leading slash.
+func cleanPath(name string) string {
+	return strings.TrimPrefix(path.Clean("/"+name), "/")
+}
+
+// readLayerFiles lists the entries of a layer tarball, recording whiteouts.
+// Directories carry no bytes of their own and are skipped.
+func readLayerFiles(r io.Reader) ([]layerFile, error) {
+	layer, err := decompress(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress layer: %w", err)
+	}
+
+	var files []layerFile
+	err = walkTar(layer, func(header *tar.Header, _ io.Reader) error {
+		name := cleanPath(header.Name)
+		dir, base := path.Split(name)
+		dir = strings.TrimSuffix(dir, "/")
+		switch {
+		case base == ".wh..wh..opq":
+			files = append(files, layerFile{Path: dir, Whiteout: true, Opaque: true})
+		case strings.HasPrefix(base, ".wh."):
+			files = append(files, layerFile{Path: path.Join(dir, strings.TrimPrefix(base, ".wh.")), Whiteout: true})
+		case header.Typeflag == tar.TypeDir:
+		default:
+			files = append(files, layerFile{Path: name, Size: header.Size})
+		}
+		return nil
+	})
+	return files, err
+}
+
+// readSaveManifest reads manifest.json from a `docker save` tarball.
+// Only single-image tarballs are supported.
+func readSaveManifest(archive string) (saveManifest, error) {
+	f, err := os.Open(archive)
+	if err != nil {
+		return saveManifest{}, err
+	}
+	defer f.Close()
+
+	var manifests []saveManifest
+	found := false
+	err = walkTar(f, func(header *tar.Header, r io.Reader) error {
+		if cleanPath(header.Name) != "manifest.json" {
+			return nil
+		}
+		found = true
+		return json.NewDecoder(r).Decode(&manifests)
+	})
+	if err != nil {
+		return saveManifest{}, fmt.Errorf("failed to read manifest.json: %w", err)
+	}
+	if !found {
+		return saveManifest{}, fmt.Errorf("no manifest.json in %s", archive)
+	}
+	if len(manifests) != 1 {
+		return saveManifest{}, fmt.Errorf("expected one image in %s, found %d", archive, len(manifests))
+	}
+	return manifests[0], nil
+}
+
+// readSaveLayers returns the files of every layer in a `docker save` tarball, in manifest order.
+// The tarball is streamed, never extracted.
+func readSaveLayers(archive string, manifest saveManifest) ([][]layerFile, error) {
+	// A layer can be listed more than once in the manifest but is stored once in the tarball.
+	pending := make(map[string][]int, len(manifest.Layers))
+	for i, layer := range manifest.Layers {
+		pending[cleanPath(layer)] = append(pending[cleanPath(layer)], i)
+	}
+
+	layers := make([][]layerFile, len(manifest.Layers))
+	read := make(map[string][]layerFile)
+	visit := func(header *tar.Header, r io.Reader) error {
+		name := cleanPath(header.Name)
+		indexes, ok := pending[name]
+		if !ok {
+			return nil
+		}
+		delete(pending, name)
+
+		if header.Typeflag == tar.TypeSymlink {
+			// Older versions of docker save store a repeated layer once and link the others to it.
+			target := cleanPath(path.Join(path.Dir(name), header.Linkname))
+			if files, ok := read[target]; ok {
+				for _, i := range indexes {
+					layers[i] = files
+				}
+			} else {
+				pending[target] = append(pending[target], indexes...)
+			}
+			return nil
+		}
+
+		files, err := readLayerFiles(r)
+		if err != nil {
+			return fmt.Errorf("layer %s: %w", header.Name, err)
+		}
+		read[name] = files
+		for _, i := range indexes {
+			layers[i] = files
+		}
+		return nil
+	}
+
+	// A link whose target isn't listed in the manifest and comes before it in the tarball
+	// leaves the target pending after the first pass; a second pass picks it up.
+	for pass := 0; pass < 2 && len(pending) > 0; pass++ {
+		f, err := os.Open(archive)
+		if err != nil {
+			return nil, err
+		}
+		err = walkTar(f, visit)
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	for name := range pending {
+		return nil, fmt.Errorf("layer %s not found in %s", name, archive)
+	}
+	return layers, nil
+}
+
+// ociBlobPath returns the path of a blob in an OCI layout directory.
+func ociBlobPath(dir, digest string) (string, error) {
+	algorithm, hex, ok := strings.Cut(digest, ":")
+	if !ok || algorithm == "" || hex == "" || strings.ContainsAny(hex, `/\.`) {
+		return "", fmt.Errorf("invalid digest: %s", digest)
+	}
+	return filepath.Join(dir, "blobs", algorithm, hex), nil
+}
+
+// readOCIBlob decodes a JSON blob from an OCI layout directory.
+func readOCIBlob(dir, digest string, v interface{}) error {
+	blob, err := ociBlobPath(dir, digest)
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(blob)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("failed to parse blob %s: %w", digest, err)
+	}
+	return nil
+}
+
+// containerdImageName is the annotation of an index entry holding the full reference of the
+// image, as written by buildx and containerd.
+const containerdImageName = "io.containerd.image.name"
+
+// readOCIImageName returns the reference recorded for the single image of an OCI layout
+// directory, or an empty string if there is none.
+func readOCIImageName(dir string) string {
+	data, err := os.ReadFile(filepath.Join(dir, "index.json"))
+	if err != nil {
+		return ""
+	}
+	var index ociManifest
+	if err := json.Unmarshal(data, &index); err != nil || len(index.Manifests) != 1 {
+		return ""
+	}
+	return index.Manifests[0].Annotations[containerdImageName]
+}
+
+// maxOCIIndexDepth caps how many image indexes readOCIManifest follows to reach a manifest.
+const maxOCIIndexDepth = 8
+
+// readOCIManifest returns the image manifest of an OCI layout directory, following
+// nested image indexes up to maxOCIIndexDepth deep. Only layouts holding a single image
+// are supported.
+func readOCIManifest(dir string) (ociManifest, error) {
+	data, err := os.ReadFile(filepath.Join(dir, "index.json"))
+	if err != nil {
+		return ociManifest{}, err
+	}
+	var manifest ociManifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return ociManifest{}, fmt.Errorf("failed to parse index.json: %w", err)
+	}
+
+	for depth := 0; len(manifest.Manifests) > 0; depth++ {
+		if depth == maxOCIIndexDepth {
+			return ociManifest{}, fmt.Errorf("image indexes in %s are nested more than %d deep", dir, maxOCIIndexDepth)
+		}
+		if len(manifest.Manifests) != 1 {
+			return ociManifest{}, fmt.Errorf("expected one image in %s, found %d manifests", dir, len(manifest.Manifests))
+		}
+		digest := manifest.Manifests[0].Digest
+		manifest = ociManifest{}
+		if err := readOCIBlob(dir, digest, &manifest); err != nil {
+			return ociManifest{}, err
+		}
+	}
+	return manifest, nil
+}
+
+// readOCILayers returns the files of every layer of an OCI layout image, in manifest order.
+func readOCILayers(dir string, manifest ociManifest) ([][]layerFile, error) {
+	layers := make([][]layerFile, 0, len(manifest.Layers))
+	for _, descriptor := range manifest.Layers {
+		blob, err := ociBlobPath(dir, descriptor.Digest)
+		if err != nil {
+			return nil, err
+		}
+		f, err := os.Open(blob)
+		if err != nil {
+			return nil, err
+		}
+		files, err := readLayerFiles(f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("layer %s: %w", descriptor.Digest, err)
+		}
+		layers = append(layers, files)
+	}
+	return layers, nil
+}
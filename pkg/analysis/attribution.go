@@ -0,0 +1,171 @@
+package analysis
+
+import (
+	"fmt"
+	"path"
+	"sort"
+)
+
+// AttributionPolicy decides which images pay for a layer. It receives the layer and
+// the images that contain it, and returns the images the layer's bytes are charged to.
+// The bytes are split evenly between the returned images.
+type AttributionPolicy func(layer DockerLayer, holders []*DockerImage) []*DockerImage
+
+// imageCreated returns the creation time of the newest layer in an image, which is when it was built.
+func imageCreated(image *DockerImage) (created int64) {
+	for _, layer := range image.Layers {
+		if t := layer.Created.UnixNano(); t > created {
+			created = t
+		}
+	}
+	return created
+}
+
+// FirstCreatorPolicy charges a shared layer to the image that was built first.
+func FirstCreatorPolicy(layer DockerLayer, holders []*DockerImage) []*DockerImage {
+	first := holders[0]
+	for _, image := range holders[1:] {
+		if imageCreated(image) < imageCreated(first) {
+			first = image
+		}
+	}
+	return []*DockerImage{first}
+}
+
+// SplitEvenlyPolicy splits a shared layer evenly between every image that contains it.
+func SplitEvenlyPolicy(layer DockerLayer, holders []*DockerImage) []*DockerImage {
+	return holders
+}
+
+// BaseOwnerPolicy charges a shared layer to the holders whose name matches one of the
+// owner patterns (path.Match syntax, e.g. "registry.local/base/*"). Layers not held
+// by any owner are attributed with the fallback policy.
+func BaseOwnerPolicy(owners []string, fallback AttributionPolicy) (AttributionPolicy, error) {
+	for _, pattern := range owners {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid owner pattern %q: %w", pattern, err)
+		}
+	}
+	return func(layer DockerLayer, holders []*DockerImage) []*DockerImage {
+		var payers []*DockerImage
+		for _, image := range holders {
+			for _, pattern := range owners {
+				if ok, _ := path.Match(pattern, image.Name); ok {
+					payers = append(payers, image)
+					break
+				}
+			}
+		}
+		if len(payers) == 0 {
+			return fallback(layer, holders)
+		}
+		return payers
+	}, nil
+}
+
+// nonNilImages returns images without its nil entries.
+func nonNilImages(images []*DockerImage) []*DockerImage {
+	var result []*DockerImage
+	for _, image := range images {
+		if image != nil {
+			result = append(result, image)
+		}
+	}
+	return result
+}
+
+// sharedLayer is a distinct layer in a fleet together with the images that contain it.
+type sharedLayer struct {
+	layer   DockerLayer
+	holders []*DockerImage
+}
+
+// collectSharedLayers returns the distinct layers of a fleet in first-seen order. Layers with
+// a "<missing>" ID can't be matched across images and are kept distinct per image.
+func collectSharedLayers(fleet []*DockerImage) []*sharedLayer {
+	var order []*sharedLayer
+	layers := make(map[string]*sharedLayer)
+	for i, image := range fleet {
+		for j, layer := range image.Layers {
+			key := layer.ID
+			if key == "<missing>" {
+				key = fmt.Sprintf("<missing>/%d/%d", i, j)
+			}
+			shared, ok := layers[key]
+			if !ok {
+				shared = &sharedLayer{layer: layer}
+				layers[key] = shared
+				order = append(order, shared)
+			}
+			if n := len(shared.holders); n == 0 || shared.holders[n-1] != image {
+				shared.holders = append(shared.holders, image)
+			}
+		}
+	}
+	return order
+}
+
+// UniqueSize returns the storage a set of images takes when every distinct layer is stored once,
+// as opposed to the sum of their sizes. Layers with a "<missing>" ID can't be matched across
+// images and are counted for each image they appear in.
+func UniqueSize(images []*DockerImage) int64 {
+	var total int64
+	for _, shared := range collectSharedLayers(nonNilImages(images)) {
+		total += shared.layer.Size
+	}
+	return total
+}
+
+// DedupedSize returns the size of the image counting each distinct layer ID once.
+func (image *DockerImage) DedupedSize() int64 {
+	return UniqueSize([]*DockerImage{image})
+}
+
+// AttributeSharedLayers returns the bytes attributed to each image in the fleet, in fleet order.
+// Every distinct layer is counted once and charged according to the policy, so the values always
+// sum to the deduplicated size of the fleet. Bytes that don't divide evenly between the images
+// charged for a layer go to those earliest in the fleet. Layers with a "<missing>" ID can't be
+// matched across images and are charged to the image they appear in. Nil images are attributed nothing.
+func AttributeSharedLayers(fleet []*DockerImage, policy AttributionPolicy) []int64 {
+	result := make([]int64, len(fleet))
+	position := make(map[*DockerImage]int, len(fleet))
+	for i, image := range fleet {
+		if _, ok := position[image]; !ok && image != nil {
+			position[image] = i
+		}
+	}
+	for _, shared := range collectSharedLayers(nonNilImages(fleet)) {
+		payers := holdersOnly(policy(shared.layer, shared.holders), shared.holders)
+		if len(payers) == 0 {
+			payers = shared.holders
+		}
+		sort.SliceStable(payers, func(i, j int) bool {
+			return position[payers[i]] < position[payers[j]]
+		})
+
+		share := shared.layer.Size / int64(len(payers))
+		remainder := shared.layer.Size % int64(len(payers))
+		for i, image := range payers {
+			result[position[image]] += share
+			// Hand out the bytes that don't divide evenly one at a time so the total stays exact.
+			if int64(i) < remainder {
+				result[position[image]]++
+			}
+		}
+	}
+	return result
+}
+
+// holdersOnly drops the images returned by a policy that don't actually contain the layer.
+func holdersOnly(payers, holders []*DockerImage) []*DockerImage {
+	var result []*DockerImage
+	for _, payer := range payers {
+		for _, holder := range holders {
+			if payer == holder {
+				result = append(result, payer)
+				break
+			}
+		}
+	}
+	return result
+}
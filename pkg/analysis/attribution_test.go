@@ -0,0 +1,94 @@
+package analysis
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestAttributeSharedLayersSumsToUniqueSize(t *testing.T) {
+	day := func(d int) time.Time { return time.Date(2023, 1, d, 0, 0, 0, 0, time.UTC) }
+	base := DockerLayer{ID: "sha256:base", Size: 1001, Created: day(1)}
+	runtime := DockerLayer{ID: "sha256:runtime", Size: 333, Created: day(2)}
+	fleet := []*DockerImage{
+		{Name: "base/debian", Layers: []DockerLayer{base}},
+		{Name: "app/api", Layers: []DockerLayer{base, runtime, {ID: "sha256:api", Size: 17, Created: day(3)}}},
+		nil,
+		{Name: "app/worker", Layers: []DockerLayer{
+			base, runtime,
+			{ID: "<missing>", Size: 5, Created: day(4)},
+			{ID: "sha256:worker", Size: 7, Created: day(4)},
+			{ID: "sha256:worker", Size: 7, Created: day(4)}, // repeated within the image
+		}},
+		{Name: "app/empty"},
+	}
+
+	baseOwner, err := BaseOwnerPolicy([]string{"base/*"}, SplitEvenlyPolicy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	strangers := func(layer DockerLayer, holders []*DockerImage) []*DockerImage {
+		return []*DockerImage{{Name: "not/a/holder"}}
+	}
+
+	want := UniqueSize(fleet)
+	if want != 1001+333+17+5+7 {
+		t.Fatalf("UniqueSize = %d, want %d", want, 1001+333+17+5+7)
+	}
+	for _, tc := range []struct {
+		name   string
+		policy AttributionPolicy
+	}{
+		{"first creator", FirstCreatorPolicy},
+		{"split evenly", SplitEvenlyPolicy},
+		{"base owner", baseOwner},
+		{"non-holders", strangers},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			attributed := AttributeSharedLayers(fleet, tc.policy)
+			if len(attributed) != len(fleet) {
+				t.Errorf("got %d sizes for %d images: %v", len(attributed), len(fleet), attributed)
+			}
+			if attributed[2] != 0 || attributed[4] != 0 {
+				t.Errorf("nil and empty images were attributed bytes: %v", attributed)
+			}
+			var total int64
+			for i, size := range attributed {
+				if size < 0 {
+					t.Errorf("image %d: negative size %d", i, size)
+				}
+				total += size
+			}
+			if total != want {
+				t.Errorf("attributed sizes sum to %d, want %d: %v", total, want, attributed)
+			}
+		})
+	}
+}
+
+func TestAttributeSharedLayersByPosition(t *testing.T) {
+	base := DockerLayer{ID: "sha256:base", Size: 1001}
+	fleet := []*DockerImage{
+		{Name: "app", Layers: []DockerLayer{base, {ID: "sha256:a", Size: 10}}},
+		{Name: "app", Layers: []DockerLayer{base, {ID: "sha256:b", Size: 20}}},
+		{Layers: []DockerLayer{{ID: "sha256:c", Size: 30}}},
+		{Layers: []DockerLayer{base}},
+	}
+
+	for _, tc := range []struct {
+		name   string
+		policy AttributionPolicy
+		want   []int64
+	}{
+		// The base layer is split three ways; the remainder goes to the earliest images.
+		{"split evenly", SplitEvenlyPolicy, []int64{334 + 10, 334 + 20, 30, 333}},
+		{"first creator", FirstCreatorPolicy, []int64{1001 + 10, 20, 30, 0}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got := AttributeSharedLayers(fleet, tc.policy)
+			if fmt.Sprint(got) != fmt.Sprint(tc.want) {
+				t.Errorf("got %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,122 @@
+package analysis
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// DefaultBaseImages lists well-known base images that LoadBaseImages fetches when given no
+// references. Callers with other bases can pass their own list, or append to this one.
+var DefaultBaseImages = []string{
+	"debian:bookworm",
+	"debian:bookworm-slim",
+	"ubuntu:24.04",
+	"ubuntu:22.04",
+	"alpine:3.20",
+	"alpine:3.19",
+	"gcr.io/distroless/static-debian12",
+	"gcr.io/distroless/base-debian12",
+	"gcr.io/distroless/cc-debian12",
+}
+
+// sameLayer reports whether two layers are the same layer. Layers with a "<missing>" ID, as
+// docker history shows for pulled images, match when they were created by the same
+// instruction at the same time with the same size.
+func sameLayer(a, b DockerLayer) bool {
+	if a.ID != "<missing>" && a.ID != "" {
+		return a.ID == b.ID
+	}
+	if b.ID != a.ID {
+		return false
+	}
+	return a.CreatedBy == b.CreatedBy && a.Size == b.Size && a.Created.Equal(b.Created)
+}
+
+// sharedPrefixLen returns the number of leading layers a and b have in common.
+func sharedPrefixLen(a, b *DockerImage) int {
+	n := 0
+	for n < len(a.Layers) && n < len(b.Layers) && sameLayer(a.Layers[n], b.Layers[n]) {
+		n++
+	}
+	return n
+}
+
+// SharedLayerPrefix returns the leading layers a and b have in common, starting from the base
+// layer and stopping at the first layer that differs.
+func SharedLayerPrefix(a, b *DockerImage) []DockerLayer {
+	n := sharedPrefixLen(a, b)
+	if n == 0 {
+		return nil
+	}
+	return append([]DockerLayer(nil), a.Layers[:n]...)
+}
+
+// LoadBaseImages fetches candidate base images for SplitBaseLayers from their registries, or
+// DefaultBaseImages when refs is empty. Most of them are multi-arch, so opts.Platform should be
+// set to the platform of the image being split. Images that can't be fetched are left out and
+// their errors joined in the returned error, so the images that were fetched are usable even
+// when it isn't nil.
+//
+// Layers of registry images are identified by their compressed digests, which `docker history`
+// doesn't report for pulled layers; compare them with images loaded by NewDockerImageFromRegistry.
+func LoadBaseImages(ctx context.Context, refs []string, opts RegistryOptions) ([]*DockerImage, error) {
+	if len(refs) == 0 {
+		refs = DefaultBaseImages
+	}
+	var images []*DockerImage
+	var errs []error
+	for _, ref := range refs {
+		image, err := NewDockerImageFromRegistry(ctx, ref, opts)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", ref, err))
+			continue
+		}
+		images = append(images, image)
+	}
+	return images, errors.Join(errs...)
+}
+
+// SplitBaseLayers splits the layers of the image into those of its base image and those added
+// on top of it. The base is the candidate sharing the longest layer prefix with the image; the
+// first one wins a tie. If no candidate shares a layer, base is nil, app holds every layer and
+// matchedBase is empty. Candidates can be loaded from the local daemon, or from a registry with
+// LoadBaseImages, which defaults to the well-known bases in DefaultBaseImages.
+func (image *DockerImage) SplitBaseLayers(knownBases []*DockerImage) (base, app []DockerLayer, matchedBase string) {
+	best := 0
+	for _, candidate := range knownBases {
+		if candidate == nil {
+			continue
+		}
+		if n := sharedPrefixLen(image, candidate); n > best {
+			best = n
+			matchedBase = candidate.Name
+		}
+	}
+	if best > 0 {
+		base = append([]DockerLayer(nil), image.Layers[:best]...)
+	}
+	app = append([]DockerLayer{}, image.Layers[best:]...)
+	return base, app, matchedBase
+}
+
+// BaseSplit is an image's layers split into those of its base image and those added on top.
+type BaseSplit struct {
+	Base     []DockerLayer
+	App      []DockerLayer
+	BaseSize int64 // in bytes
+	AppSize  int64 // in bytes
+}
+
+// SplitBaseAndApp splits the layers of image into the leading layers it shares with baseImage
+// and the application layers on top of them, along with the size of each group. Matching stops
+// at the first layer that differs.
+func SplitBaseAndApp(image *DockerImage, baseImage *DockerImage) BaseSplit {
+	base, app, _ := image.SplitBaseLayers([]*DockerImage{baseImage})
+	return BaseSplit{
+		Base:     base,
+		App:      app,
+		BaseSize: TotalSize(base),
+		AppSize:  TotalSize(app),
+	}
+}
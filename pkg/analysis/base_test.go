@@ -0,0 +1,114 @@
+package analysis
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestSplitBaseAndApp(t *testing.T) {
+	base := &DockerImage{Name: "python:3.12-slim", Layers: []DockerLayer{
+		{ID: "sha256:os", Size: 75},
+		{ID: "sha256:python", Size: 40},
+	}}
+	image := &DockerImage{Name: "app", Layers: []DockerLayer{
+		{ID: "sha256:os", Size: 75},
+		{ID: "sha256:python", Size: 40},
+		{ID: "sha256:deps", Size: 300},
+		{ID: "sha256:code", Size: 12},
+	}}
+
+	for _, tc := range []struct {
+		name              string
+		base              *DockerImage
+		baseLen, appLen   int
+		baseSize, appSize int64
+	}{
+		{"matching base", base, 2, 2, 115, 312},
+		{"no base", nil, 0, 4, 0, 427},
+		{"unrelated base", &DockerImage{Layers: []DockerLayer{{ID: "sha256:alpine", Size: 7}}}, 0, 4, 0, 427},
+		{"diverging base", &DockerImage{Layers: []DockerLayer{{ID: "sha256:os", Size: 75}, {ID: "sha256:node", Size: 50}}}, 1, 3, 75, 352},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			split := SplitBaseAndApp(image, tc.base)
+			if len(split.Base) != tc.baseLen || len(split.App) != tc.appLen {
+				t.Errorf("got %d base and %d app layers, want %d and %d", len(split.Base), len(split.App), tc.baseLen, tc.appLen)
+			}
+			if split.BaseSize != tc.baseSize || split.AppSize != tc.appSize {
+				t.Errorf("got base %d and app %d bytes, want %d and %d", split.BaseSize, split.AppSize, tc.baseSize, tc.appSize)
+			}
+		})
+	}
+}
+
+// fakeRegistry serves single-platform images whose layers are given by their digests.
+func fakeRegistry(t *testing.T, images map[string][]string) *httptest.Server {
+	t.Helper()
+	blobs := make(map[string][]byte)
+	manifests := make(map[string][]byte)
+	for repoTag, layers := range images {
+		config := map[string]interface{}{"architecture": "amd64", "os": "linux"}
+		history := []map[string]string{}
+		descriptors := []map[string]interface{}{}
+		for _, layer := range layers {
+			history = append(history, map[string]string{"created_by": "RUN " + layer})
+			descriptors = append(descriptors, map[string]interface{}{"mediaType": "application/vnd.oci.image.layer.v1.tar+gzip", "digest": layer, "size": 100})
+		}
+		config["history"] = history
+		configData, _ := json.Marshal(config)
+		blobs[sha256Digest(configData)] = configData
+		manifest, _ := json.Marshal(map[string]interface{}{
+			"schemaVersion": 2,
+			"mediaType":     "application/vnd.oci.image.manifest.v1+json",
+			"config":        map[string]interface{}{"mediaType": "application/vnd.oci.image.config.v1+json", "digest": sha256Digest(configData), "size": len(configData)},
+			"layers":        descriptors,
+		})
+		repo, tag, _ := strings.Cut(repoTag, ":")
+		manifests["/v2/"+repo+"/manifests/"+tag] = manifest
+	}
+
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if data, ok := manifests[r.URL.Path]; ok {
+			w.Header().Set("Content-Type", "application/vnd.oci.image.manifest.v1+json")
+			w.Write(data)
+			return
+		}
+		if _, digest, ok := strings.Cut(r.URL.Path, "/blobs/"); ok && blobs[digest] != nil {
+			w.Write(blobs[digest])
+			return
+		}
+		http.NotFound(w, r)
+	}))
+}
+
+func TestLoadBaseImages(t *testing.T) {
+	server := fakeRegistry(t, map[string][]string{
+		"debian:12":   {"sha256:d1"},
+		"python:3.12": {"sha256:d1", "sha256:p1"},
+		"app:1":       {"sha256:d1", "sha256:p1", "sha256:a1"},
+	})
+	defer server.Close()
+	host := strings.TrimPrefix(server.URL, "http://")
+	opts := RegistryOptions{Insecure: true}
+
+	bases, err := LoadBaseImages(context.Background(), []string{host + "/debian:12", host + "/python:3.12", host + "/missing:1"}, opts)
+	if !errors.Is(err, ErrImageNotFound) || !strings.Contains(err.Error(), host+"/missing:1") {
+		t.Errorf("got error %v, want one naming the missing image", err)
+	}
+	if len(bases) != 2 {
+		t.Fatalf("got %d base images, want 2", len(bases))
+	}
+
+	image, err := NewDockerImageFromRegistry(context.Background(), host+"/app:1", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	base, app, matched := image.SplitBaseLayers(bases)
+	if matched != host+"/python:3.12" || len(base) != 2 || len(app) != 1 {
+		t.Errorf("got base %q with %d layers and %d app layers, want python:3.12 with 2 and 1", matched, len(base), len(app))
+	}
+}
@@ -0,0 +1,97 @@
+package analysis
+
+import "fmt"
+
+// Budget holds the limits an image must stay within, for example to fail a CI build.
+// A zero field means no limit.
+type Budget struct {
+	MaxImageSize         int64 // in bytes
+	MaxLayerSize         int64 // in bytes
+	MaxLayers            int   // number of layers that add files
+	MaxLayersWithoutTags int
+}
+
+// BudgetViolation is a limit of a Budget that an image exceeds.
+type BudgetViolation struct {
+	Constraint string `json:"constraint"`
+	LayerID    string `json:"layerId,omitempty"` // set for MaxLayerSize violations
+	Actual     int64  `json:"actual"`
+	Allowed    int64  `json:"allowed"`
+	Message    string `json:"message"`
+}
+
+// BudgetResult is the outcome of checking an image against a Budget.
+type BudgetResult struct {
+	Image      string            `json:"image"`
+	Violations []BudgetViolation `json:"violations"`
+}
+
+// OK reports whether the image is within budget.
+func (r BudgetResult) OK() bool {
+	return len(r.Violations) == 0
+}
+
+// ExitCode returns the process exit status for the result: 0 within budget, 1 otherwise.
+func (r BudgetResult) ExitCode() int {
+	if r.OK() {
+		return 0
+	}
+	return 1
+}
+
+// CheckBudget checks the image against b and returns every limit it exceeds. As with
+// LayerCountRule, only layers that add files count towards MaxLayers.
+func (image *DockerImage) CheckBudget(b Budget) BudgetResult {
+	result := BudgetResult{Image: image.Name, Violations: []BudgetViolation{}}
+
+	size := image.Size
+	if size == 0 {
+		size = TotalSize(image.Layers)
+	}
+	if b.MaxImageSize > 0 && size > b.MaxImageSize {
+		result.Violations = append(result.Violations, BudgetViolation{
+			Constraint: "maxImageSize",
+			Actual:     size,
+			Allowed:    b.MaxImageSize,
+			Message:    fmt.Sprintf("image is %s, more than %s", FormatSize(size), FormatSize(b.MaxImageSize)),
+		})
+	}
+
+	if b.MaxLayerSize > 0 {
+		for _, layer := range LayersLargerThan(image.Layers, b.MaxLayerSize) {
+			result.Violations = append(result.Violations, BudgetViolation{
+				Constraint: "maxLayerSize",
+				LayerID:    layer.ID,
+				Actual:     layer.Size,
+				Allowed:    b.MaxLayerSize,
+				Message: fmt.Sprintf("layer %s is %s, more than %s: %s", shortID(layer.ID),
+					FormatSize(layer.Size), FormatSize(b.MaxLayerSize), truncate(normalizeInstruction(layer.CreatedBy), 80)),
+			})
+		}
+	}
+
+	if b.MaxLayers > 0 {
+		count := len(LayersLargerThan(image.Layers, 0))
+		if count > b.MaxLayers {
+			result.Violations = append(result.Violations, BudgetViolation{
+				Constraint: "maxLayers",
+				Actual:     int64(count),
+				Allowed:    int64(b.MaxLayers),
+				Message:    fmt.Sprintf("image has %d layers, more than %d", count, b.MaxLayers),
+			})
+		}
+	}
+
+	if b.MaxLayersWithoutTags > 0 {
+		count := len(LayersWithoutTags(image.Layers))
+		if count > b.MaxLayersWithoutTags {
+			result.Violations = append(result.Violations, BudgetViolation{
+				Constraint: "maxLayersWithoutTags",
+				Actual:     int64(count),
+				Allowed:    int64(b.MaxLayersWithoutTags),
+				Message:    fmt.Sprintf("image has %d untagged layers, more than %d", count, b.MaxLayersWithoutTags),
+			})
+		}
+	}
+	return result
+}
@@ -0,0 +1,104 @@
+package analysis
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+// budgetImage is a 155 MB image with three layers that add files and one tagged layer.
+var budgetImage = &DockerImage{Name: "app:1.4", Size: 155000000, Layers: []DockerLayer{
+	{ID: "sha256:base0123456789", Size: 120000000, CreatedBy: "/bin/sh -c #(nop) ADD file:abc in / "},
+	{ID: "<missing>", CreatedBy: "/bin/sh -c #(nop)  ENV LANG=C.UTF-8"},
+	{ID: "sha256:deps0123456789", Size: 30000000, CreatedBy: "RUN /bin/sh -c pip install -r requirements.txt # buildkit"},
+	{ID: "sha256:app00123456789", Size: 5000000, CreatedBy: "COPY . /app # buildkit", Tags: []string{"app:1.4"}},
+}}
+
+func TestCheckBudgetUnlimited(t *testing.T) {
+	result := budgetImage.CheckBudget(Budget{})
+	if !result.OK() || result.ExitCode() != 0 || len(result.Violations) != 0 {
+		t.Errorf("zero budget: got %+v, want no violations", result)
+	}
+	if result.Image != "app:1.4" {
+		t.Errorf("Image = %q, want app:1.4", result.Image)
+	}
+}
+
+func TestCheckBudgetViolations(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		budget Budget
+		want   []BudgetViolation
+	}{
+		{"within budget", Budget{MaxImageSize: 155000000, MaxLayerSize: 120000000, MaxLayers: 3, MaxLayersWithoutTags: 3}, nil},
+		{"image size", Budget{MaxImageSize: 100000000}, []BudgetViolation{
+			{Constraint: "maxImageSize", Actual: 155000000, Allowed: 100000000, Message: "image is 155.0 MB, more than 100.0 MB"},
+		}},
+		{"layer size", Budget{MaxLayerSize: 25000000}, []BudgetViolation{
+			{Constraint: "maxLayerSize", LayerID: "sha256:base0123456789", Actual: 120000000, Allowed: 25000000,
+				Message: "layer base01234567 is 120.0 MB, more than 25.0 MB: ADD file:abc in /"},
+			{Constraint: "maxLayerSize", LayerID: "sha256:deps0123456789", Actual: 30000000, Allowed: 25000000,
+				Message: "layer deps01234567 is 30.0 MB, more than 25.0 MB: RUN pip install -r requirements.txt"},
+		}},
+		{"layers", Budget{MaxLayers: 2}, []BudgetViolation{
+			{Constraint: "maxLayers", Actual: 3, Allowed: 2, Message: "image has 3 layers, more than 2"},
+		}},
+		{"untagged layers", Budget{MaxLayersWithoutTags: 1}, []BudgetViolation{
+			{Constraint: "maxLayersWithoutTags", Actual: 3, Allowed: 1, Message: "image has 3 untagged layers, more than 1"},
+		}},
+		{"several limits", Budget{MaxImageSize: 100000000, MaxLayers: 2}, []BudgetViolation{
+			{Constraint: "maxImageSize", Actual: 155000000, Allowed: 100000000, Message: "image is 155.0 MB, more than 100.0 MB"},
+			{Constraint: "maxLayers", Actual: 3, Allowed: 2, Message: "image has 3 layers, more than 2"},
+		}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			result := budgetImage.CheckBudget(tc.budget)
+			if len(result.Violations) != len(tc.want) {
+				t.Fatalf("got violations %+v, want %+v", result.Violations, tc.want)
+			}
+			for i, want := range tc.want {
+				if got := result.Violations[i]; got != want {
+					t.Errorf("violation %d: got %+v, want %+v", i, got, want)
+				}
+			}
+			wantOK, wantCode := true, 0
+			if len(tc.want) > 0 {
+				wantOK, wantCode = false, 1
+			}
+			if result.OK() != wantOK || result.ExitCode() != wantCode {
+				t.Errorf("OK() = %t, ExitCode() = %d, want %t, %d", result.OK(), result.ExitCode(), wantOK, wantCode)
+			}
+		})
+	}
+}
+
+func TestCheckBudgetSizeFromLayers(t *testing.T) {
+	image := &DockerImage{Layers: budgetImage.Layers}
+	result := image.CheckBudget(Budget{MaxImageSize: 150000000})
+	if len(result.Violations) != 1 || result.Violations[0].Actual != 155000000 {
+		t.Errorf("got violations %+v, want the size summed from the layers", result.Violations)
+	}
+}
+
+func TestBudgetResultJSON(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		budget Budget
+		want   string
+	}{
+		{"within budget", Budget{}, `{"image":"app:1.4","violations":[]}`},
+		{"image size", Budget{MaxImageSize: 100000000},
+			`{"image":"app:1.4","violations":[{"constraint":"maxImageSize","actual":155000000,"allowed":100000000,"message":"image is 155.0 MB, more than 100.0 MB"}]}`},
+		{"layer size", Budget{MaxLayerSize: 100000000},
+			`{"image":"app:1.4","violations":[{"constraint":"maxLayerSize","layerId":"sha256:base0123456789","actual":120000000,"allowed":100000000,"message":"layer base01234567 is 120.0 MB, more than 100.0 MB: ADD file:abc in /"}]}`},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			data, err := json.Marshal(budgetImage.CheckBudget(tc.budget))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(data) != tc.want {
+				t.Errorf("got %s\nwant %s", data, tc.want)
+			}
+		})
+	}
+}
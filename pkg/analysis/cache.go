@@ -0,0 +1,290 @@
+package analysis
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// ImageCache keeps images loaded from the local docker daemon so that loading the same image
+// again doesn't rerun `docker history`. Images are keyed by their ID, a digest of their content,
+// which is resolved with `docker image inspect` on every Get; when a tag is moved to another
+// image the new ID misses the cache and the entry of the old one is dropped.
+//
+// Images returned by Get are shared between callers and must not be modified.
+type ImageCache struct {
+	client     *Client
+	ttl        time.Duration
+	maxEntries int
+	dir        string
+
+	mu      sync.Mutex
+	entries map[string]*list.Element // of *cacheEntry, by key
+	lru     *list.List               // most recently used first
+	keys    map[string]string        // last key each name resolved to
+	calls   map[string]*cacheCall    // loads in progress, by name
+}
+
+// cacheEntry is an image in the cache.
+type cacheEntry struct {
+	key    string
+	image  *DockerImage
+	stored time.Time
+}
+
+// cacheCall is a Get in progress that other Gets for the same name wait for. It runs on its
+// own context, which is canceled once every caller waiting for it has given up.
+type cacheCall struct {
+	done    chan struct{}
+	cancel  context.CancelFunc
+	waiters int // guarded by ImageCache.mu
+	image   *DockerImage
+	err     error
+}
+
+// CacheOption configures an ImageCache.
+type CacheOption func(*ImageCache)
+
+// WithCacheClient makes the cache load images through c instead of the default client.
+func WithCacheClient(c *Client) CacheOption {
+	return func(cache *ImageCache) {
+		cache.client = c
+	}
+}
+
+// WithTTL makes cached images expire ttl after they were loaded. By default they don't expire.
+func WithTTL(ttl time.Duration) CacheOption {
+	return func(cache *ImageCache) {
+		cache.ttl = ttl
+	}
+}
+
+// WithMaxEntries limits the cache to n images in memory, evicting the least recently used.
+// By default the cache isn't limited.
+func WithMaxEntries(n int) CacheOption {
+	return func(cache *ImageCache) {
+		cache.maxEntries = n
+	}
+}
+
+// WithCacheDir makes the cache store images as JSON files in dir, so they survive a restart
+// of the process. The directory is created if needed.
+func WithCacheDir(dir string) CacheOption {
+	return func(cache *ImageCache) {
+		cache.dir = dir
+	}
+}
+
+// NewImageCache creates an empty ImageCache.
+func NewImageCache(opts ...CacheOption) *ImageCache {
+	cache := ImageCache{
+		client:  defaultClient,
+		entries: make(map[string]*list.Element),
+		lru:     list.New(),
+		keys:    make(map[string]string),
+		calls:   make(map[string]*cacheCall),
+	}
+	for _, opt := range opts {
+		opt(&cache)
+	}
+	return &cache
+}
+
+// Get returns the image called name, loading its history if it isn't cached or has expired.
+// Concurrent Gets for the same name share a single load, which isn't tied to the context of
+// any one of them: a caller whose context is done returns early, and the load is only stopped
+// once no caller is waiting for it anymore.
+func (c *ImageCache) Get(ctx context.Context, name string) (*DockerImage, error) {
+	c.mu.Lock()
+	call, ok := c.calls[name]
+	if !ok {
+		loadCtx, cancel := context.WithCancel(context.Background())
+		call = &cacheCall{done: make(chan struct{}), cancel: cancel}
+		c.calls[name] = call
+		go func() {
+			defer cancel()
+			call.image, call.err = c.load(loadCtx, name)
+			c.mu.Lock()
+			if c.calls[name] == call {
+				delete(c.calls, name)
+			}
+			c.mu.Unlock()
+			close(call.done)
+		}()
+	}
+	call.waiters++
+	c.mu.Unlock()
+
+	select {
+	case <-call.done:
+		return call.image, call.err
+	case <-ctx.Done():
+		c.mu.Lock()
+		call.waiters--
+		if call.waiters == 0 {
+			// Nobody wants the result anymore. Later Gets start a new load rather than
+			// joining this one, which is being canceled.
+			call.cancel()
+			if c.calls[name] == call {
+				delete(c.calls, name)
+			}
+		}
+		c.mu.Unlock()
+		return nil, ctx.Err()
+	}
+}
+
+// load resolves the key of an image and returns it from memory, from disk or from docker, in that order.
+func (c *ImageCache) load(ctx context.Context, name string) (*DockerImage, error) {
+	inspect, err := c.client.Inspect(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	key := inspect.ID
+	if key == "" {
+		key = name + "@" + inspect.Created.UTC().Format(time.RFC3339Nano)
+	}
+
+	c.mu.Lock()
+	if old, ok := c.keys[name]; ok && old != key {
+		c.remove(old)
+	}
+	c.keys[name] = key
+	image := c.lookup(key)
+	c.mu.Unlock()
+	if image != nil {
+		return c.named(image, name), nil
+	}
+
+	image, stored := c.readFile(key)
+	if image == nil {
+		image, err = c.client.LoadImageHistory(ctx, name)
+		if err != nil {
+			return nil, err
+		}
+		stored = time.Now()
+		// The cache directory only saves work; failing to write to it doesn't fail the Get.
+		_ = c.writeFile(key, image)
+	}
+
+	c.mu.Lock()
+	c.add(key, image, stored)
+	c.mu.Unlock()
+	return c.named(image, name), nil
+}
+
+// named returns image under the name it was asked for, attached to the cache's client.
+func (c *ImageCache) named(image *DockerImage, name string) *DockerImage {
+	named := *image
+	named.Name = name
+	named.client = c.client
+	return &named
+}
+
+// expired reports whether an image stored at the given time is past the TTL.
+func (c *ImageCache) expired(stored time.Time) bool {
+	return c.ttl > 0 && time.Since(stored) > c.ttl
+}
+
+// lookup returns the image cached in memory under key, or nil. c.mu must be held.
+func (c *ImageCache) lookup(key string) *DockerImage {
+	element, ok := c.entries[key]
+	if !ok {
+		return nil
+	}
+	entry := element.Value.(*cacheEntry)
+	if c.expired(entry.stored) {
+		c.lru.Remove(element)
+		delete(c.entries, key)
+		return nil
+	}
+	c.lru.MoveToFront(element)
+	return entry.image
+}
+
+// add caches image in memory under key and evicts the least recently used images over the limit.
+// c.mu must be held.
+func (c *ImageCache) add(key string, image *DockerImage, stored time.Time) {
+	if element, ok := c.entries[key]; ok {
+		element.Value = &cacheEntry{key: key, image: image, stored: stored}
+		c.lru.MoveToFront(element)
+	} else {
+		c.entries[key] = c.lru.PushFront(&cacheEntry{key: key, image: image, stored: stored})
+	}
+	for c.maxEntries > 0 && c.lru.Len() > c.maxEntries {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cacheEntry).key)
+	}
+}
+
+// remove drops the image cached under key from memory and disk. c.mu must be held.
+func (c *ImageCache) remove(key string) {
+	if element, ok := c.entries[key]; ok {
+		c.lru.Remove(element)
+		delete(c.entries, key)
+	}
+	if c.dir != "" {
+		os.Remove(c.path(key))
+	}
+}
+
+// path returns the file an image is stored in on disk.
+func (c *ImageCache) path(key string) string {
+	return filepath.Join(c.dir, fmt.Sprintf("%x.json", sha256.Sum256([]byte(key))))
+}
+
+// readFile returns the image stored on disk under key and when it was stored, or nil if there
+// is none, it has expired or it can't be read.
+func (c *ImageCache) readFile(key string) (*DockerImage, time.Time) {
+	if c.dir == "" {
+		return nil, time.Time{}
+	}
+	path := c.path(key)
+	info, err := os.Stat(path)
+	if err != nil || c.expired(info.ModTime()) {
+		return nil, time.Time{}
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, time.Time{}
+	}
+	var image DockerImage
+	if err := json.Unmarshal(data, &image); err != nil {
+		return nil, time.Time{}
+	}
+	return &image, info.ModTime()
+}
+
+// writeFile stores image on disk under key, replacing the file atomically.
+func (c *ImageCache) writeFile(key string, image *DockerImage) error {
+	if c.dir == "" {
+		return nil
+	}
+	data, err := json.Marshal(image)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(c.dir, 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(c.dir, ".image-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), c.path(key))
+}
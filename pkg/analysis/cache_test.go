@@ -0,0 +1,133 @@
+package analysis
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// blockingRunner answers docker image inspect right away and blocks docker history until
+// release is closed or the command's context is done.
+type blockingRunner struct {
+	started   chan struct{}
+	release   chan struct{}
+	histories int32
+}
+
+func newBlockingRunner() *blockingRunner {
+	return &blockingRunner{started: make(chan struct{}, 1), release: make(chan struct{})}
+}
+
+func (r *blockingRunner) Run(ctx context.Context, name string, args ...string) ([]byte, error) {
+	if args[0] == "image" {
+		return []byte(`[{"Id":"sha256:aaa","Created":"2023-01-01T00:00:00Z"}]`), nil
+	}
+	atomic.AddInt32(&r.histories, 1)
+	select {
+	case r.started <- struct{}{}:
+	default:
+	}
+	select {
+	case <-r.release:
+		return []byte(`{"ID":"sha256:l1","CreatedAt":"2023-01-01T00:00:00Z","CreatedBy":"RUN make","Size":"10","Tags":"","Comment":""}`), nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// waitersFor returns the number of callers waiting for the load of name.
+func (c *ImageCache) waitersFor(name string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if call, ok := c.calls[name]; ok {
+		return call.waiters
+	}
+	return 0
+}
+
+func TestImageCacheGetSurvivesCanceledCaller(t *testing.T) {
+	runner := newBlockingRunner()
+	cache := NewImageCache(WithCacheClient(NewClient(WithRunner(runner))))
+
+	firstCtx, cancelFirst := context.WithCancel(context.Background())
+	firstErr := make(chan error, 1)
+	go func() {
+		_, err := cache.Get(firstCtx, "app:1")
+		firstErr <- err
+	}()
+	<-runner.started
+
+	type result struct {
+		image *DockerImage
+		err   error
+	}
+	second := make(chan result, 1)
+	go func() {
+		image, err := cache.Get(context.Background(), "app:1")
+		second <- result{image, err}
+	}()
+	for cache.waitersFor("app:1") < 2 {
+		time.Sleep(time.Millisecond)
+	}
+
+	cancelFirst()
+	if err := <-firstErr; !errors.Is(err, context.Canceled) {
+		t.Fatalf("first Get: got error %v, want context.Canceled", err)
+	}
+	close(runner.release)
+
+	got := <-second
+	if got.err != nil {
+		t.Fatalf("second Get: %v", got.err)
+	}
+	if len(got.image.Layers) != 1 || got.image.Name != "app:1" {
+		t.Errorf("second Get returned %+v", got.image)
+	}
+	if n := atomic.LoadInt32(&runner.histories); n != 1 {
+		t.Errorf("docker history ran %d times, want 1", n)
+	}
+}
+
+func TestImageCacheGetCancelsAbandonedLoad(t *testing.T) {
+	runner := newBlockingRunner()
+	cache := NewImageCache(WithCacheClient(NewClient(WithRunner(runner))))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errc := make(chan error, 1)
+	go func() {
+		_, err := cache.Get(ctx, "app:1")
+		errc <- err
+	}()
+	<-runner.started
+	cancel()
+	if err := <-errc; !errors.Is(err, context.Canceled) {
+		t.Fatalf("Get: got error %v, want context.Canceled", err)
+	}
+
+	// The abandoned load is canceled, so a later Get starts a new one instead of joining it.
+	close(runner.release)
+	image, err := cache.Get(context.Background(), "app:1")
+	if err != nil {
+		t.Fatalf("Get after cancel: %v", err)
+	}
+	if len(image.Layers) != 1 {
+		t.Errorf("Get after cancel returned %d layers, want 1", len(image.Layers))
+	}
+}
+
+func TestImageCacheGetDeduplicatesLoads(t *testing.T) {
+	runner := newBlockingRunner()
+	close(runner.release)
+	cache := NewImageCache(WithCacheClient(NewClient(WithRunner(runner))), WithCacheDir(t.TempDir()))
+
+	for i := 0; i < 3; i++ {
+		if _, err := cache.Get(context.Background(), "app:1"); err != nil {
+			t.Fatalf("Get %d: %v", i, err)
+		}
+	}
+	if n := atomic.LoadInt32(&runner.histories); n != 1 {
+		t.Errorf("docker history ran %d times, want 1", n)
+	}
+}
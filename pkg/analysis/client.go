@@ -0,0 +1,174 @@
+package analysis
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+	"sync"
+)
+
+// Runner runs an external command and returns its standard output.
+type Runner interface {
+	Run(ctx context.Context, name string, args ...string) ([]byte, error)
+}
+
+// RunnerFunc adapts an ordinary function to the Runner interface,
+// which is handy for replacing docker with canned output in tests.
+type RunnerFunc func(ctx context.Context, name string, args ...string) ([]byte, error)
+
+// Run calls f(ctx, name, args...).
+func (f RunnerFunc) Run(ctx context.Context, name string, args ...string) ([]byte, error) {
+	return f(ctx, name, args...)
+}
+
+// execRunner runs commands with os/exec.
+type execRunner struct{}
+
+// Run runs the command and, if it fails, includes what it printed to standard error in the error.
+func (execRunner) Run(ctx context.Context, name string, args ...string) ([]byte, error) {
+	output, err := exec.CommandContext(ctx, name, args...).Output()
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && len(exitErr.Stderr) > 0 {
+			return nil, fmt.Errorf("%s: %w", strings.TrimSpace(string(exitErr.Stderr)), err)
+		}
+		return nil, err
+	}
+	return output, nil
+}
+
+// Client loads images through the docker CLI.
+type Client struct {
+	runner Runner
+}
+
+// Option configures a Client.
+type Option func(*Client)
+
+// WithRunner makes the client run docker commands through r instead of os/exec.
+func WithRunner(r Runner) Option {
+	return func(c *Client) {
+		c.runner = r
+	}
+}
+
+// NewClient creates a Client. By default it runs the docker binary found in PATH.
+func NewClient(opts ...Option) *Client {
+	c := Client{runner: execRunner{}}
+	for _, opt := range opts {
+		opt(&c)
+	}
+	return &c
+}
+
+// defaultClient is used by the package-level functions and by images not loaded through a Client.
+var defaultClient = NewClient()
+
+// docker runs a docker command. Errors wrap ErrImageNotFound when docker reports a missing
+// image, and the context's error when the command was stopped because the context is done.
+func (c *Client) docker(ctx context.Context, args ...string) ([]byte, error) {
+	output, err := c.runner.Run(ctx, "docker", args...)
+	if err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return nil, fmt.Errorf("docker %s: %w", args[0], ctxErr)
+		}
+		if strings.Contains(err.Error(), "No such image") {
+			return nil, fmt.Errorf("%w: %s", ErrImageNotFound, err)
+		}
+		return nil, err
+	}
+	return output, nil
+}
+
+// InspectRaw returns the unparsed JSON output of `docker image inspect` for an image.
+func (c *Client) InspectRaw(ctx context.Context, name string) ([]byte, error) {
+	output, err := c.docker(ctx, "image", "inspect", name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to inspect image: %w", err)
+	}
+	return output, nil
+}
+
+// Inspect gets detailed information about an image using `docker image inspect`.
+func (c *Client) Inspect(ctx context.Context, name string) (*ImageInspect, error) {
+	output, err := c.InspectRaw(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	return parseInspect(name, output)
+}
+
+// LoadImageHistory builds a DockerImage from the `docker history` of a local image.
+// See NewDockerImageFromHistory for how layers are assembled.
+func (c *Client) LoadImageHistory(ctx context.Context, name string) (*DockerImage, error) {
+	output, err := c.docker(ctx, "history", "--no-trunc", "--human=false", "--format", "{{json .}}", name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get image history: %w", err)
+	}
+
+	image, err := NewDockerImageFromHistory(strings.Split(string(output), "\n"))
+	if err != nil {
+		return nil, err
+	}
+	image.Name = name
+	image.client = c
+	return image, nil
+}
+
+// NewDockerImage builds a DockerImage for a local image from `docker image inspect` and `docker history`.
+// It returns an error wrapping ErrImageNotFound if the image hasn't been pulled or built locally.
+func (c *Client) NewDockerImage(ctx context.Context, name string) (*DockerImage, error) {
+	if _, err := c.Inspect(ctx, name); err != nil {
+		return nil, err
+	}
+	return c.LoadImageHistory(ctx, name)
+}
+
+// LoadImages loads the history of many local images, running up to concurrency docker
+// commands at a time. Images are returned in the order of names; the image of a name that
+// failed is nil, and the returned error joins the errors of every failed name. Once the
+// context is done no more commands are started, and the remaining names fail with its error.
+func (c *Client) LoadImages(ctx context.Context, names []string, concurrency int) ([]*DockerImage, error) {
+	images := make([]*DockerImage, len(names))
+	errs := c.loadEach(ctx, names, concurrency, func(ctx context.Context, i int, name string) (err error) {
+		images[i], err = c.LoadImageHistory(ctx, name)
+		return err
+	})
+	return images, errors.Join(errs...)
+}
+
+// loadEach calls load for each name, up to concurrency at a time, and returns the error of
+// each name. Once the context is done no more loads are started, and the remaining names
+// fail with its error.
+func (c *Client) loadEach(ctx context.Context, names []string, concurrency int, load func(ctx context.Context, i int, name string) error) []error {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	errs := make([]error, len(names))
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for i, name := range names {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if err := ctx.Err(); err != nil {
+			errs[i] = fmt.Errorf("%s: %w", name, err)
+			continue
+		}
+
+		wg.Add(1)
+		go func(i int, name string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			if err := load(ctx, i, name); err != nil {
+				errs[i] = fmt.Errorf("%s: %w", name, err)
+			}
+		}(i, name)
+	}
+	wg.Wait()
+	return errs
+}
@@ -0,0 +1,205 @@
+package analysis
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeDocker answers docker commands with the contents of fixture files, so that the client can
+// be tested without a docker daemon. Commands are keyed by their subcommand: "history" or
+// "image inspect". A command without a fixture fails like docker does for a missing image.
+type fakeDocker struct {
+	t        *testing.T
+	fixtures map[string]string // subcommand -> file under testdata
+	errs     map[string]error  // subcommand -> error to fail with
+	calls    [][]string
+}
+
+func (f *fakeDocker) runner() Runner {
+	return RunnerFunc(func(ctx context.Context, name string, args ...string) ([]byte, error) {
+		f.calls = append(f.calls, append([]string{name}, args...))
+		command := args[0]
+		if command == "image" {
+			command += " " + args[1]
+		}
+		if err, ok := f.errs[command]; ok {
+			return nil, err
+		}
+		fixture, ok := f.fixtures[command]
+		if !ok {
+			return nil, errors.New("Error response from daemon: No such image: " + args[len(args)-1] + ": exit status 1")
+		}
+		data, err := os.ReadFile(filepath.Join("testdata", fixture))
+		if err != nil {
+			f.t.Fatal(err)
+		}
+		return data, nil
+	})
+}
+
+func (f *fakeDocker) client() *Client {
+	return NewClient(WithRunner(f.runner()))
+}
+
+func TestClientLoadImageHistory(t *testing.T) {
+	docker := &fakeDocker{t: t, fixtures: map[string]string{"history": "history.jsonl"}}
+	image, err := docker.client().LoadImageHistory(context.Background(), "app:1.4")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"docker", "history", "--no-trunc", "--human=false", "--format", "{{json .}}", "app:1.4"}
+	if len(docker.calls) != 1 || strings.Join(docker.calls[0], " ") != strings.Join(want, " ") {
+		t.Errorf("ran %q, want %q", docker.calls, want)
+	}
+
+	if image.Name != "app:1.4" {
+		t.Errorf("Name = %q, want app:1.4", image.Name)
+	}
+	if image.Size != 96780288 {
+		t.Errorf("Size = %d, want 96780288", image.Size)
+	}
+	if len(image.Layers) != 5 {
+		t.Fatalf("got %d layers, want 5", len(image.Layers))
+	}
+
+	// docker history lists the newest layer first; layers are stored base first.
+	for i, want := range []struct {
+		command string
+		size    int64
+	}{
+		{"ADD", 74760192},
+		{"CMD", 0},
+		{"RUN", 9437184},
+		{"COPY", 12582912},
+		{"CMD", 0},
+	} {
+		layer := image.Layers[i]
+		if layer.Command != want.command || layer.Size != want.size {
+			t.Errorf("layer %d: got %s of %d bytes, want %s of %d bytes", i, layer.Command, layer.Size, want.command, want.size)
+		}
+		if i == 0 && layer.Parent != nil || i > 0 && layer.Parent != &image.Layers[i-1] {
+			t.Errorf("layer %d: wrong parent", i)
+		}
+	}
+
+	top := image.Layers[4]
+	if top.ID != "sha256:5d2f6c5d4e0b7f2e3a1c9b8d7e6f5a4b3c2d1e0f9a8b7c6d5e4f3a2b1c0d9e8f" {
+		t.Errorf("top layer ID = %q", top.ID)
+	}
+	if created := time.Date(2023, 6, 14, 9, 12, 40, 0, time.UTC); !top.Created.Equal(created) {
+		t.Errorf("top layer Created = %v, want %v", top.Created, created)
+	}
+}
+
+func TestClientInspect(t *testing.T) {
+	docker := &fakeDocker{t: t, fixtures: map[string]string{"image inspect": "inspect.json"}}
+	inspect, err := docker.client().Inspect(context.Background(), "app:1.4")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if inspect.ID != "sha256:5d2f6c5d4e0b7f2e3a1c9b8d7e6f5a4b3c2d1e0f9a8b7c6d5e4f3a2b1c0d9e8f" {
+		t.Errorf("ID = %q", inspect.ID)
+	}
+	if len(inspect.RepoTags) != 1 || inspect.RepoTags[0] != "app:1.4" {
+		t.Errorf("RepoTags = %q", inspect.RepoTags)
+	}
+	if inspect.Os != "linux" || inspect.Architecture != "amd64" || inspect.Size != 96780288 {
+		t.Errorf("got %s/%s of %d bytes", inspect.Os, inspect.Architecture, inspect.Size)
+	}
+	if inspect.Config.User != "app" || inspect.Config.WorkingDir != "/app" || len(inspect.Config.Cmd) != 1 {
+		t.Errorf("Config = %+v", inspect.Config)
+	}
+	if _, ok := inspect.Config.ExposedPorts["8080/tcp"]; !ok {
+		t.Errorf("ExposedPorts = %v, want 8080/tcp", inspect.Config.ExposedPorts)
+	}
+	if len(inspect.RootFS.Layers) != 3 {
+		t.Errorf("got %d RootFS layers, want 3", len(inspect.RootFS.Layers))
+	}
+	if created := time.Date(2023, 6, 14, 9, 12, 40, 123456789, time.UTC); !inspect.Created.Equal(created) {
+		t.Errorf("Created = %v, want %v", inspect.Created, created)
+	}
+}
+
+func TestClientNewDockerImage(t *testing.T) {
+	docker := &fakeDocker{t: t, fixtures: map[string]string{
+		"image inspect": "inspect.json",
+		"history":       "history.jsonl",
+	}}
+	client := docker.client()
+	image, err := client.NewDockerImage(context.Background(), "app:1.4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(image.Layers) != 5 {
+		t.Errorf("got %d layers, want 5", len(image.Layers))
+	}
+	if image.client != client {
+		t.Error("image isn't attached to the client that loaded it")
+	}
+}
+
+func TestClientMalformedOutput(t *testing.T) {
+	docker := &fakeDocker{t: t, fixtures: map[string]string{
+		"image inspect": "inspect_malformed.json",
+		"history":       "history_malformed.jsonl",
+	}}
+	client := docker.client()
+
+	if _, err := client.LoadImageHistory(context.Background(), "app:1.4"); err == nil || !strings.Contains(err.Error(), "invalid history entry") {
+		t.Errorf("LoadImageHistory: got error %v, want an invalid history entry error", err)
+	}
+	if _, err := client.Inspect(context.Background(), "app:1.4"); err == nil || !strings.Contains(err.Error(), "failed to parse inspect output") {
+		t.Errorf("Inspect: got error %v, want a parse error", err)
+	}
+}
+
+func TestClientCommandFailure(t *testing.T) {
+	exitErr := errors.New("Cannot connect to the Docker daemon at unix:///var/run/docker.sock: exit status 1")
+	for _, tc := range []struct {
+		name     string
+		docker   *fakeDocker
+		notFound bool
+	}{
+		{"missing image", &fakeDocker{}, true},
+		{"daemon down", &fakeDocker{errs: map[string]error{"image inspect": exitErr, "history": exitErr}}, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.docker.t = t
+			client := tc.docker.client()
+
+			_, inspectErr := client.Inspect(context.Background(), "app:1.4")
+			_, historyErr := client.LoadImageHistory(context.Background(), "app:1.4")
+			_, imageErr := client.NewDockerImage(context.Background(), "app:1.4")
+			for _, err := range []error{inspectErr, historyErr, imageErr} {
+				if err == nil {
+					t.Fatal("got no error")
+				}
+				if got := errors.Is(err, ErrImageNotFound); got != tc.notFound {
+					t.Errorf("errors.Is(%v, ErrImageNotFound) = %t, want %t", err, got, tc.notFound)
+				}
+				if !tc.notFound && !errors.Is(err, exitErr) {
+					t.Errorf("error %v doesn't wrap the runner's error", err)
+				}
+			}
+		})
+	}
+}
+
+func TestClientCanceledContext(t *testing.T) {
+	client := NewClient(WithRunner(RunnerFunc(func(ctx context.Context, name string, args ...string) ([]byte, error) {
+		<-ctx.Done()
+		return nil, errors.New("signal: killed")
+	})))
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := client.LoadImageHistory(ctx, "app:1.4"); !errors.Is(err, context.Canceled) {
+		t.Errorf("got error %v, want context.Canceled", err)
+	}
+}
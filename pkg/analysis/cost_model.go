@@ -0,0 +1,132 @@
+package analysis
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// minModelSamples is the number of layers an instruction needs in the fleet before
+// layers built from it are scored against the model.
+const minModelSamples = 3
+
+// InstructionSizeStats holds the distribution of layer sizes observed for one instruction template.
+type InstructionSizeStats struct {
+	Count  int   `json:"count"`
+	Min    int64 `json:"min"`
+	Q1     int64 `json:"q1"`
+	Median int64 `json:"median"`
+	Q3     int64 `json:"q3"`
+	Max    int64 `json:"max"`
+}
+
+// CostModel holds the layer size distribution per normalized instruction across a fleet of images.
+type CostModel struct {
+	Images       int                             `json:"images"`
+	Instructions map[string]InstructionSizeStats `json:"instructions"`
+}
+
+// Deviation describes a layer whose size is an outlier compared to the fleet's history for its instruction.
+type Deviation struct {
+	Layer       DockerLayer
+	Instruction string
+	Expected    InstructionSizeStats
+}
+
+// String returns a human-readable description of the deviation.
+func (d Deviation) String() string {
+	return fmt.Sprintf("%s is usually %s-%s in the fleet; this layer is %s",
+		d.Instruction, HumanSize(d.Expected.Q1), HumanSize(d.Expected.Q3), HumanSize(d.Layer.Size))
+}
+
+// quantile returns the q-th quantile (0 <= q <= 1) of sorted values using linear interpolation.
+func quantile(sorted []int64, q float64) int64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := q * float64(len(sorted)-1)
+	lower := int(rank)
+	if lower+1 >= len(sorted) {
+		return sorted[len(sorted)-1]
+	}
+	fraction := rank - float64(lower)
+	return sorted[lower] + int64(fraction*float64(sorted[lower+1]-sorted[lower]))
+}
+
+// BuildCostModel aggregates the layer sizes of every image in the fleet per normalized instruction.
+func BuildCostModel(fleet []*DockerImage) *CostModel {
+	sizes := make(map[string][]int64)
+	for _, image := range fleet {
+		for _, layer := range image.Layers {
+			instruction := instructionTemplate(layer.CreatedBy)
+			if instruction == "" {
+				continue
+			}
+			sizes[instruction] = append(sizes[instruction], layer.Size)
+		}
+	}
+
+	model := CostModel{
+		Images:       len(fleet),
+		Instructions: make(map[string]InstructionSizeStats, len(sizes)),
+	}
+	for instruction, values := range sizes {
+		sort.Slice(values, func(i, j int) bool {
+			return values[i] < values[j]
+		})
+		model.Instructions[instruction] = InstructionSizeStats{
+			Count:  len(values),
+			Min:    values[0],
+			Q1:     quantile(values, 0.25),
+			Median: quantile(values, 0.5),
+			Q3:     quantile(values, 0.75),
+			Max:    values[len(values)-1],
+		}
+	}
+	return &model
+}
+
+// ScoreAgainstModel returns the layers of an image whose size falls outside the
+// interquartile fences of the fleet's distribution for the same instruction.
+// Instructions seen fewer than minModelSamples times in the fleet are not scored,
+// and nothing is scored without a model.
+func ScoreAgainstModel(image *DockerImage, model *CostModel) []Deviation {
+	if model == nil {
+		return nil
+	}
+	var deviations []Deviation
+	for _, layer := range image.Layers {
+		instruction := instructionTemplate(layer.CreatedBy)
+		stats, ok := model.Instructions[instruction]
+		if !ok || stats.Count < minModelSamples {
+			continue
+		}
+		iqr := stats.Q3 - stats.Q1
+		if layer.Size > stats.Q3+iqr*3/2 || layer.Size < stats.Q1-iqr*3/2 {
+			deviations = append(deviations, Deviation{
+				Layer:       layer,
+				Instruction: instruction,
+				Expected:    stats,
+			})
+		}
+	}
+	return deviations
+}
+
+// Save writes the cost model as JSON.
+func (model *CostModel) Save(w io.Writer) error {
+	if err := json.NewEncoder(w).Encode(model); err != nil {
+		return fmt.Errorf("failed to write cost model: %w", err)
+	}
+	return nil
+}
+
+// LoadCostModel reads a cost model previously written with Save.
+func LoadCostModel(r io.Reader) (*CostModel, error) {
+	var model CostModel
+	if err := json.NewDecoder(r).Decode(&model); err != nil {
+		return nil, fmt.Errorf("failed to read cost model: %w", err)
+	}
+	return &model, nil
+}
@@ -0,0 +1,82 @@
+package analysis
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// costFleet returns images that each install packages and copy a file with a different digest.
+func costFleet(sizes ...int64) []*DockerImage {
+	var fleet []*DockerImage
+	for i, size := range sizes {
+		fleet = append(fleet, &DockerImage{Layers: []DockerLayer{
+			{ID: fmt.Sprintf("sha256:copy%d", i), Size: 10, CreatedBy: fmt.Sprintf("/bin/sh -c #(nop) COPY file:%064x in / ", i)},
+			{ID: fmt.Sprintf("sha256:apt%d", i), Size: size, CreatedBy: "/bin/sh -c apt-get install -y curl"},
+			{ID: "<missing>", CreatedBy: ""},
+		}})
+	}
+	return fleet
+}
+
+func TestBuildCostModel(t *testing.T) {
+	model := BuildCostModel(costFleet(130, 100, 120, 110))
+	if model.Images != 4 || len(model.Instructions) != 2 {
+		t.Fatalf("got %d images and instructions %v, want 4 images and 2 instructions", model.Images, model.Instructions)
+	}
+	for instruction, want := range map[string]InstructionSizeStats{
+		"RUN apt-get install -y curl": {Count: 4, Min: 100, Q1: 107, Median: 115, Q3: 122, Max: 130},
+		"COPY file:<digest> in /":     {Count: 4, Min: 10, Q1: 10, Median: 10, Q3: 10, Max: 10},
+	} {
+		if got := model.Instructions[instruction]; got != want {
+			t.Errorf("%s: got %+v, want %+v", instruction, got, want)
+		}
+	}
+}
+
+func TestScoreAgainstModel(t *testing.T) {
+	model := BuildCostModel(costFleet(130, 100, 120, 110))
+	for _, tc := range []struct {
+		name  string
+		model *CostModel
+		size  int64
+		want  []string
+	}{
+		// The fences are 107-22 and 122+22.
+		{"within the fences", model, 144, nil},
+		{"above the fences", model, 145, []string{"RUN apt-get install -y curl is usually 107 B-122 B in the fleet; this layer is 145 B"}},
+		{"below the fences", model, 84, []string{"RUN apt-get install -y curl is usually 107 B-122 B in the fleet; this layer is 84 B"}},
+		{"too few samples", BuildCostModel(costFleet(100, 110)), 1000, nil},
+		{"no model", nil, 1000, nil},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var got []string
+			for _, deviation := range ScoreAgainstModel(costFleet(tc.size)[0], tc.model) {
+				got = append(got, deviation.String())
+			}
+			if fmt.Sprint(got) != fmt.Sprint(tc.want) {
+				t.Errorf("got %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestCostModelSaveLoad(t *testing.T) {
+	model := BuildCostModel(costFleet(130, 100, 120, 110))
+	var buf bytes.Buffer
+	if err := model.Save(&buf); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadCostModel(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(loaded) != fmt.Sprint(model) {
+		t.Errorf("got %+v, want %+v", loaded, model)
+	}
+
+	if _, err := LoadCostModel(strings.NewReader("{")); err == nil || !strings.Contains(err.Error(), "failed to read cost model") {
+		t.Errorf("got error %v, want a read error", err)
+	}
+}
@@ -0,0 +1,100 @@
+package analysis
+
+import (
+	"encoding/csv"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// layerCSVFields returns the value of each column a layer CSV can have, by header.
+var layerCSVFields = map[string]func(layer DockerLayer) string{
+	"ID":        func(layer DockerLayer) string { return layer.ID },
+	"Size":      func(layer DockerLayer) string { return strconv.FormatInt(layer.Size, 10) },
+	"HumanSize": func(layer DockerLayer) string { return HumanSize(layer.Size) },
+	"Created":   func(layer DockerLayer) string { return layer.Created.Format(time.RFC3339) },
+	"Author":    func(layer DockerLayer) string { return layer.Author },
+	"Command":   func(layer DockerLayer) string { return layer.Command },
+	"CreatedBy": func(layer DockerLayer) string { return layer.CreatedBy },
+	"Tags":      func(layer DockerLayer) string { return strings.Join(layer.Tags, ",") },
+}
+
+// layersCSVHeader is the header row written by WriteLayersCSV.
+var layersCSVHeader = []string{"ID", "Size", "HumanSize", "Created", "Author", "Command", "CreatedBy", "Tags"}
+
+// compactCSVHeader is the header row written by WriteCSV.
+var compactCSVHeader = []string{"ID", "Size", "Command", "Author", "Created", "Tags"}
+
+// layerCSVRecord returns the CSV row of a layer with the columns of header.
+func layerCSVRecord(layer DockerLayer, header []string) []string {
+	record := make([]string, len(header))
+	for i, column := range header {
+		record[i] = layerCSVFields[column](layer)
+	}
+	return record
+}
+
+// summaryCSVRecord returns a summary row with a label in the ID column and a size in the size columns.
+func summaryCSVRecord(label string, size int64) []string {
+	record := make([]string, len(layersCSVHeader))
+	record[0] = label
+	record[1] = strconv.FormatInt(size, 10)
+	record[2] = HumanSize(size)
+	return record
+}
+
+// writeLayerRecords writes the header row and one row per layer with its columns.
+func writeLayerRecords(cw *csv.Writer, header []string, layers []DockerLayer) error {
+	if err := cw.Write(header); err != nil {
+		return err
+	}
+	for _, layer := range layers {
+		if err := cw.Write(layerCSVRecord(layer, header)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteLayersCSV writes the layers as CSV: a header row followed by one row per layer.
+// Fields with commas, quotes or newlines, such as multi-line RUN commands, are quoted.
+func WriteLayersCSV(w io.Writer, layers []DockerLayer) error {
+	cw := csv.NewWriter(w)
+	if err := writeLayerRecords(cw, layersCSVHeader, layers); err != nil {
+		return err
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// WriteImageCSV writes the layers of an image like WriteLayersCSV, followed by an empty row
+// and summary rows with the total, average and median layer sizes.
+func WriteImageCSV(w io.Writer, image *DockerImage) error {
+	cw := csv.NewWriter(w)
+	if err := writeLayerRecords(cw, layersCSVHeader, image.Layers); err != nil {
+		return err
+	}
+
+	summary := [][]string{
+		make([]string, len(layersCSVHeader)),
+		summaryCSVRecord("total", TotalSize(image.Layers)),
+		summaryCSVRecord("average", int64(AverageSize(image.Layers))),
+		summaryCSVRecord("median", MedianSize(image.Layers)),
+	}
+	if err := cw.WriteAll(summary); err != nil {
+		return err
+	}
+	return cw.Error()
+}
+
+// WriteCSV writes a compact CSV of the layers with their ID, size in bytes, command, author,
+// creation time and tags. Use WriteLayersCSV to also include CreatedBy and human-readable sizes.
+func WriteCSV(w io.Writer, layers []DockerLayer) error {
+	cw := csv.NewWriter(w)
+	if err := writeLayerRecords(cw, compactCSVHeader, layers); err != nil {
+		return err
+	}
+	cw.Flush()
+	return cw.Error()
+}
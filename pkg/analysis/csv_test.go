@@ -0,0 +1,48 @@
+package analysis
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+var csvLayers = []DockerLayer{
+	{ID: "sha256:base", Size: 2048, Created: time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC), CreatedBy: "ADD rootfs.tar /"},
+	{ID: "sha256:app", Size: 1024, Created: time.Date(2023, 1, 2, 0, 0, 0, 0, time.UTC), Author: "ops",
+		Command: "RUN make", CreatedBy: "RUN make \\\n  install", Tags: []string{"app:1", "app:latest"}},
+}
+
+func TestWriteCSV(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		write func(*strings.Builder) error
+		want  string
+	}{
+		{"compact", func(b *strings.Builder) error { return WriteCSV(b, csvLayers) }, "" +
+			"ID,Size,Command,Author,Created,Tags\n" +
+			"sha256:base,2048,,,2023-01-01T00:00:00Z,\n" +
+			"sha256:app,1024,RUN make,ops,2023-01-02T00:00:00Z,\"app:1,app:latest\"\n"},
+		{"layers", func(b *strings.Builder) error { return WriteLayersCSV(b, csvLayers) }, "" +
+			"ID,Size,HumanSize,Created,Author,Command,CreatedBy,Tags\n" +
+			"sha256:base,2048,2.0 KB,2023-01-01T00:00:00Z,,,ADD rootfs.tar /,\n" +
+			"sha256:app,1024,1.0 KB,2023-01-02T00:00:00Z,ops,RUN make,\"RUN make \\\n  install\",\"app:1,app:latest\"\n"},
+		{"image", func(b *strings.Builder) error { return WriteImageCSV(b, &DockerImage{Layers: csvLayers}) }, "" +
+			"ID,Size,HumanSize,Created,Author,Command,CreatedBy,Tags\n" +
+			"sha256:base,2048,2.0 KB,2023-01-01T00:00:00Z,,,ADD rootfs.tar /,\n" +
+			"sha256:app,1024,1.0 KB,2023-01-02T00:00:00Z,ops,RUN make,\"RUN make \\\n  install\",\"app:1,app:latest\"\n" +
+			",,,,,,,\n" +
+			"total,3072,3.0 KB,,,,,\n" +
+			"average,1536,1.5 KB,,,,,\n" +
+			"median,1536,1.5 KB,,,,,\n"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var b strings.Builder
+			if err := tc.write(&b); err != nil {
+				t.Fatal(err)
+			}
+			if b.String() != tc.want {
+				t.Errorf("got\n%s\nwant\n%s", b.String(), tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,133 @@
+package analysis
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ImageDiff describes the layer differences between two images.
+type ImageDiff struct {
+	AddedLayers   []DockerLayer    // layers only in the second image
+	RemovedLayers []DockerLayer    // layers only in the first image
+	CommonLayers  []DockerLayer    // layers in both images
+	LayerDeltas   []LayerSizeDelta // added and removed layers built by the same command with different sizes
+	SizeDelta     int64            // total size of the second image minus the first, in bytes
+}
+
+// LayerSizeDelta describes a layer that was rebuilt from the same command with a different size.
+type LayerSizeDelta struct {
+	CreatedBy string
+	OldSize   int64
+	NewSize   int64
+}
+
+// Delta returns the size difference of the layer, in bytes.
+func (d LayerSizeDelta) Delta() int64 {
+	return d.NewSize - d.OldSize
+}
+
+// diffKey returns the key a layer is matched on: its ID or, when docker reports the ID as
+// "<missing>", its CreatedBy, size and creation time, as sameLayer compares them.
+func diffKey(layer DockerLayer) string {
+	if layer.ID != "<missing>" && layer.ID != "" {
+		return layer.ID
+	}
+	return fmt.Sprintf("%s\x00%s\x00%d\x00%s", layer.ID, layer.CreatedBy, layer.Size, layer.Created.UTC().Format(time.RFC3339Nano))
+}
+
+// DiffImages compares two images layer by layer, matching layers by ID regardless of their position.
+// Layers whose ID is "<missing>" are matched by CreatedBy, size and creation time instead, so a
+// layer rebuilt from the same command with a different size is reported in LayerDeltas.
+func DiffImages(a, b *DockerImage) ImageDiff {
+	var diff ImageDiff
+
+	// Count layer keys so that a layer repeated in one image is only matched as often as it appears in the other.
+	available := make(map[string]int)
+	for _, layer := range a.Layers {
+		available[diffKey(layer)]++
+	}
+	matched := make(map[string]int)
+	for _, layer := range b.Layers {
+		key := diffKey(layer)
+		if matched[key] < available[key] {
+			matched[key]++
+			diff.CommonLayers = append(diff.CommonLayers, layer)
+		} else {
+			diff.AddedLayers = append(diff.AddedLayers, layer)
+		}
+	}
+	for _, layer := range a.Layers {
+		key := diffKey(layer)
+		if matched[key] > 0 {
+			matched[key]--
+		} else {
+			diff.RemovedLayers = append(diff.RemovedLayers, layer)
+		}
+	}
+
+	// Pair removed and added layers built by the same command to show how their size changed.
+	paired := make(map[int]bool)
+	for _, removed := range diff.RemovedLayers {
+		for i, added := range diff.AddedLayers {
+			if paired[i] || added.CreatedBy != removed.CreatedBy {
+				continue
+			}
+			paired[i] = true
+			if added.Size != removed.Size {
+				diff.LayerDeltas = append(diff.LayerDeltas, LayerSizeDelta{
+					CreatedBy: added.CreatedBy,
+					OldSize:   removed.Size,
+					NewSize:   added.Size,
+				})
+			}
+			break
+		}
+	}
+
+	diff.SizeDelta = TotalSize(b.Layers) - TotalSize(a.Layers)
+	return diff
+}
+
+// Empty reports whether the two images have the same layers.
+func (diff ImageDiff) Empty() bool {
+	return len(diff.AddedLayers) == 0 && len(diff.RemovedLayers) == 0 && diff.SizeDelta == 0
+}
+
+// signedSize formats a size difference with an explicit sign.
+func signedSize(delta int64) string {
+	if delta < 0 {
+		return "-" + HumanSize(-delta)
+	}
+	return "+" + HumanSize(delta)
+}
+
+// truncate shortens s to at most width characters, marking the cut with "...".
+func truncate(s string, width int) string {
+	if width <= 3 || len(s) <= width {
+		return s
+	}
+	return s[:width-3] + "..."
+}
+
+// String returns a readable summary of the diff.
+func (diff ImageDiff) String() string {
+	if diff.Empty() {
+		return "no changes"
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d added, %d removed, %d common layers; size change %s\n",
+		len(diff.AddedLayers), len(diff.RemovedLayers), len(diff.CommonLayers), signedSize(diff.SizeDelta))
+	for _, layer := range diff.AddedLayers {
+		fmt.Fprintf(&b, "+ %-12s %10s  %s\n", shortID(layer.ID), HumanSize(layer.Size), truncate(normalizeInstruction(layer.CreatedBy), 80))
+	}
+	for _, layer := range diff.RemovedLayers {
+		fmt.Fprintf(&b, "- %-12s %10s  %s\n", shortID(layer.ID), HumanSize(layer.Size), truncate(normalizeInstruction(layer.CreatedBy), 80))
+	}
+	for _, delta := range diff.LayerDeltas {
+		fmt.Fprintf(&b, "~ %s: %s -> %s (%s)\n", truncate(normalizeInstruction(delta.CreatedBy), 80),
+			HumanSize(delta.OldSize), HumanSize(delta.NewSize), signedSize(delta.Delta()))
+	}
+	return b.String()
+}
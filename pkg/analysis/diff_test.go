@@ -0,0 +1,36 @@
+package analysis
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDiffImagesRebuiltMissingLayer(t *testing.T) {
+	created := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	base := DockerLayer{ID: "<missing>", CreatedBy: "ADD rootfs.tar /", Size: 1000, Created: created}
+	a := &DockerImage{Layers: []DockerLayer{base, {ID: "<missing>", CreatedBy: "RUN make", Size: 100, Created: created}}}
+	b := &DockerImage{Layers: []DockerLayer{base, {ID: "<missing>", CreatedBy: "RUN make", Size: 500, Created: created}}}
+
+	diff := DiffImages(a, b)
+	if len(diff.CommonLayers) != 1 || len(diff.AddedLayers) != 1 || len(diff.RemovedLayers) != 1 {
+		t.Fatalf("got %d common, %d added, %d removed layers, want 1, 1, 1",
+			len(diff.CommonLayers), len(diff.AddedLayers), len(diff.RemovedLayers))
+	}
+	want := LayerSizeDelta{CreatedBy: "RUN make", OldSize: 100, NewSize: 500}
+	if len(diff.LayerDeltas) != 1 || diff.LayerDeltas[0] != want {
+		t.Errorf("LayerDeltas = %+v, want [%+v]", diff.LayerDeltas, want)
+	}
+	if diff.SizeDelta != 400 {
+		t.Errorf("SizeDelta = %d, want 400", diff.SizeDelta)
+	}
+}
+
+func TestDiffImagesIdentical(t *testing.T) {
+	image := &DockerImage{Layers: []DockerLayer{
+		{ID: "<missing>", CreatedBy: "ADD rootfs.tar /", Size: 1000},
+		{ID: "sha256:abc", CreatedBy: "RUN make", Size: 100},
+	}}
+	if diff := DiffImages(image, image); !diff.Empty() || len(diff.CommonLayers) != 2 {
+		t.Errorf("diff of an image with itself = %+v, want empty with 2 common layers", diff)
+	}
+}
@@ -1,15 +1,20 @@
 package analysis
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"os/exec"
-	"sort"
-	"strconv"
 	"strings"
 	"time"
 )
 
+// ErrImageNotFound is returned when an image is not present in the local docker daemon.
+var ErrImageNotFound = errors.New("image not found")
+
+// ErrParentCycle is returned when following a layer's Parent links leads back to the layer chain itself.
+var ErrParentCycle = errors.New("layer parents form a cycle")
+
 // DockerLayer holds information about a Docker layer.
 type DockerLayer struct {
 	ID        string
@@ -18,26 +23,57 @@ type DockerLayer struct {
 	Author    string
 	Created   time.Time
 	CreatedBy string
+	Comment   string
 	Tags      []string
 	Parent    *DockerLayer
 }
 
 // DockerImage holds information about a docker image
 type DockerImage struct {
-	Name   string
+	Name string
+	// Layers are ordered base first. The Parent of each layer points to the element before it
+	// in this slice, so the slice must not be reordered in place; sort a copy instead.
 	Layers []DockerLayer
 	Size   int64 // Total size in bytes
+
+	client imageInspector // answers Inspect; the default client when nil
 }
 
-// NewDockerLayer creates a new DockerLayer from a line of output from `docker history`.
+// NewDockerLayer creates a new DockerLayer from a whitespace-separated line of output from `docker history`.
+// The line holds the ID, size, command, author, creation time, comma-separated tags and
+// CreatedBy, in that order; CreatedBy is the rest of the line. The size is either a number of
+// bytes or a human-readable size like "12.3MB" (see ParseSize). A command or author that
+// contains spaces can't be parsed this way; use NewDockerLayerDelimited for those.
 func NewDockerLayer(line string, parent *DockerLayer) (*DockerLayer, error) {
 	fields := strings.Fields(line)
 
 	if len(fields) < 6 {
 		return nil, fmt.Errorf("invalid line: %s", line)
 	}
+	fields = append(fields[:6], strings.Join(fields[6:], " "))
+	return newDockerLayerFromFields(fields, parent)
+}
+
+// NewDockerLayerDelimited creates a new DockerLayer from a line whose fields are separated by delimiter,
+// such as a tab. The fields are the same as for NewDockerLayer, but each may contain spaces,
+// and CreatedBy may also contain the delimiter since it is the last field.
+func NewDockerLayerDelimited(line, delimiter string, parent *DockerLayer) (*DockerLayer, error) {
+	if delimiter == "" {
+		return nil, fmt.Errorf("empty delimiter")
+	}
+	fields := strings.SplitN(line, delimiter, 7)
+	if len(fields) < 7 {
+		return nil, fmt.Errorf("invalid line: %s", line)
+	}
+	for i := range fields {
+		fields[i] = strings.TrimSpace(fields[i])
+	}
+	return newDockerLayerFromFields(fields, parent)
+}
 
-	size, err := strconv.ParseInt(fields[1], 10, 64)
+// newDockerLayerFromFields creates a DockerLayer from the seven fields of a history line.
+func newDockerLayerFromFields(fields []string, parent *DockerLayer) (*DockerLayer, error) {
+	size, err := ParseSize(fields[1])
 	if err != nil {
 		return nil, fmt.Errorf("invalid size: %w", err)
 	}
@@ -47,7 +83,7 @@ func NewDockerLayer(line string, parent *DockerLayer) (*DockerLayer, error) {
 		return nil, fmt.Errorf("invalid creation time: %w", err)
 	}
 
-	tags := strings.Split(fields[5], ",")
+	tags := normalizeTags(strings.Split(fields[5], ","))
 
 	layer := DockerLayer{
 		ID:        fields[0],
@@ -63,6 +99,20 @@ func NewDockerLayer(line string, parent *DockerLayer) (*DockerLayer, error) {
 	return &layer, nil
 }
 
+// normalizeTags trims whitespace around tags and drops the empty and "<none>" placeholders
+// docker prints for untagged layers. It returns nil when no real tag is left.
+func normalizeTags(tags []string) []string {
+	var result []string
+	for _, tag := range tags {
+		tag = strings.TrimSpace(tag)
+		if tag == "" || tag == "<none>" || tag == "<none>:<none>" {
+			continue
+		}
+		result = append(result, tag)
+	}
+	return result
+}
+
 // ParentLayer returns the parent layer of the given Docker layer, or nil if it has no parent.
 func ParentLayer(layer *DockerLayer) *DockerLayer {
 	return layer.Parent
@@ -70,82 +120,133 @@ func ParentLayer(layer *DockerLayer) *DockerLayer {
 
 // LayerDetails returns a string with detailed information about a DockerLayer.
 func (layer *DockerLayer) LayerDetails() string {
-	return fmt.Sprintf("ID: %s, Size: %d bytes, Command: %s, Author: %s, Created: %s, CreatedBy: %s, Tags: %v",
-		layer.ID, layer.Size, layer.Command, layer.Author, layer.Created, layer.CreatedBy, layer.Tags)
+	return fmt.Sprintf("ID: %s, Size: %s (%d bytes), Command: %s, Author: %s, Created: %s, CreatedBy: %s, Tags: %v",
+		layer.ID, HumanSize(layer.Size), layer.Size, layer.Command, layer.Author, layer.Created, layer.CreatedBy, layer.Tags)
 }
 
 // Hierarchy returns a string representing the full hierarchy of a DockerLayer.
+// If the parent links form a cycle, each layer of it is listed once.
 func (layer *DockerLayer) Hierarchy() string {
-	if layer.Parent == nil {
-		return layer.ID
+	chain, _ := layer.parentChain()
+	ids := make([]string, len(chain))
+	for i, l := range chain {
+		ids[len(chain)-1-i] = l.ID
+	}
+	return strings.Join(ids, " -> ")
+}
+
+// parentChain returns the layer followed by its parents, up to the root. A chain that loops
+// back on itself is cut before the first repeated layer, and cyclic is true.
+func (layer *DockerLayer) parentChain() (chain []*DockerLayer, cyclic bool) {
+	seen := make(map[*DockerLayer]bool)
+	for l := layer; l != nil; l = l.Parent {
+		if seen[l] {
+			return chain, true
+		}
+		seen[l] = true
+		chain = append(chain, l)
+	}
+	return chain, false
+}
+
+// Ancestors returns the layers from the root down to and including this layer. If the parent
+// links form a cycle, the chain starts at the last layer before it repeats.
+func (layer *DockerLayer) Ancestors() []DockerLayer {
+	chain, _ := layer.parentChain()
+	ancestors := make([]DockerLayer, len(chain))
+	for i, l := range chain {
+		ancestors[len(chain)-1-i] = *l
 	}
-	return layer.Parent.Hierarchy() + " -> " + layer.ID
+	return ancestors
+}
+
+// Depth returns the number of parents above the layer: 0 for a root layer.
+func (layer *DockerLayer) Depth() int {
+	chain, _ := layer.parentChain()
+	return len(chain) - 1
 }
 
 // CumulativeSize returns the cumulative size of a DockerLayer and all its ancestors.
+// If the parent links form a cycle, each layer of it is counted once.
 func (layer *DockerLayer) CumulativeSize() int64 {
-	if layer.Parent == nil {
-		return layer.Size
+	chain, _ := layer.parentChain()
+	var total int64
+	for _, l := range chain {
+		total += l.Size
 	}
-	return layer.Size + layer.Parent.CumulativeSize()
+	return total
+}
+
+// CheckParents returns ErrParentCycle if following the layer's Parent links never reaches a root.
+func (layer *DockerLayer) CheckParents() error {
+	if _, cyclic := layer.parentChain(); cyclic {
+		return fmt.Errorf("layer %s: %w", shortID(layer.ID), ErrParentCycle)
+	}
+	return nil
+}
+
+// Age returns how long ago the layer was created.
+func (layer *DockerLayer) Age() time.Duration {
+	return layer.AgeAt(time.Now())
+}
+
+// AgeAt returns how old the layer was at the reference time.
+func (layer *DockerLayer) AgeAt(ref time.Time) time.Duration {
+	return ref.Sub(layer.Created)
 }
 
 // LayerToString returns a human-readable string representation of a DockerLayer.
 func (layer *DockerLayer) LayerToString() string {
-	return fmt.Sprintf("ID: %s, Size %d bytes, Command: %s, Author: %s", layer.ID, layer.Size, layer.Command, layer.Author)
+	return fmt.Sprintf("ID: %s, Size: %s (%d bytes), Command: %s, Author: %s", layer.ID, HumanSize(layer.Size), layer.Size, layer.Command, layer.Author)
 }
 
 // ImageToString returns a human-readable string representation of a DockerImage.
 func (image *DockerImage) ImageToString() string {
-	return fmt.Sprintf("Name: %s, Size: %d bytes, Layers: %d", image.Name, image.Size, len(image.Layers))
+	return fmt.Sprintf("Name: %s, Size: %s (%d bytes), Layers: %d", image.Name, HumanSize(image.Size), image.Size, len(image.Layers))
 }
 
-// Inspect gets detailed information about the docker image using `docker inspect`.
-func (image *DockerImage) Inspect() (string, error) {
-	output, err := exec.Command("docker", "insepct", image.Name).Output()
-	if err != nil {
-		return "", fmt.Errorf("failed to inspect image: %w", err)
-	}
+// Inspect gets detailed information about the docker image using `docker image inspect`.
+func (image *DockerImage) Inspect() (*ImageInspect, error) {
+	return image.InspectContext(context.Background())
+}
 
-	var inspectOutput []map[string]interface{}
-	err = json.Unmarshal(output, &inspectOutput)
-	if err != nil {
-		return "", fmt.Errorf("failed to parse inspect output: %w", err)
+// InspectContext is like Inspect but stops docker when the context is done.
+func (image *DockerImage) InspectContext(ctx context.Context) (*ImageInspect, error) {
+	return image.dockerClient().Inspect(ctx, image.Name)
+}
+
+// InspectRaw returns the unparsed JSON output of `docker image inspect` for the image.
+func (image *DockerImage) InspectRaw() ([]byte, error) {
+	return image.InspectRawContext(context.Background())
+}
+
+// InspectRawContext is like InspectRaw but stops docker when the context is done.
+func (image *DockerImage) InspectRawContext(ctx context.Context) ([]byte, error) {
+	return image.dockerClient().InspectRaw(ctx, image.Name)
+}
+
+// dockerClient returns the client the image was loaded with, or the default client.
+func (image *DockerImage) dockerClient() imageInspector {
+	if image.client != nil {
+		return image.client
 	}
-	return fmt.Sprintf("%v", inspectOutput), nil
+	return defaultClient
 }
 
 // LayersByAuthor returns all layers created by a specific author.
 func (image *DockerImage) LayersByAuthor(author string) []DockerLayer {
-	var layers []DockerLayer
-	for _, layer := range image.Layers {
-		if layer.Author == author {
-			layers = append(layers, layer)
-		}
-	}
-	return layers
+	return FilterLayers(image.Layers, ByAuthor(author))
 }
 
 // LayersByCommand returns all layers created with a specific command.
 func (image *DockerImage) LayersByCommand(command string) []DockerLayer {
-	var layers []DockerLayer
-	for _, layer := range image.Layers {
-		if layer.Command == command {
-			layers = append(layers, layer)
-		}
-	}
-	return layers
+	return FilterLayers(image.Layers, ByCommand(command))
 }
 
-// LayersInTimeRange returns all layers created in a specific time range.
+// LayersInTimeRange returns all layers created in a specific time range, including both ends.
+// See LayersInDateRange.
 func (image *DockerImage) LayersInTimeRange(start, end time.Time) []DockerLayer {
-	var layers []DockerLayer
-	for _, layer := range image.Layers {
-		if layer.Created.After(start) && layer.Created.Before(end) {
-			layers = append(layers, layer)
-		}
-	}
-	return layers
+	return LayersInDateRange(image.Layers, start, end)
 }
 
 // LastNLayers returns the last N layers
@@ -156,17 +257,9 @@ func (image *DockerImage) LastNLayers(n int) []DockerLayer {
 	return image.Layers[len(image.Layers)-n:]
 }
 
-// LargestNLayers returns the largest N layers based on size.
+// LargestNLayers returns the largest N layers based on size. See LargestLayers.
 func (image *DockerImage) LargestNLayers(n int) []DockerLayer {
-	// Copy the slice to aviod modifiying the original
-	copiedLayers := append([]DockerLayer(nil), image.Layers...)
-	sort.Slice(copiedLayers, func(i, j int) bool {
-		return copiedLayers[i].Size > copiedLayers[j].Size
-	})
-	if n > len(copiedLayers) {
-		n = len(copiedLayers)
-	}
-	return copiedLayers[:n]
+	return LargestLayers(image.Layers, n)
 }
 
 // TotalTags return the total number of tags in all layers
@@ -221,42 +314,128 @@ func (image *DockerImage) UniqueTags() []string {
 	return tags
 }
 
-// Analyze takes a Docker image name and analyzes the image.
-func Analyze(imageName string) (*DockerImage, error) {
-	fmt.Println("Analyzing image: ", imageName)
+// historyEntry is one line of `docker history --format '{{json .}}'` output.
+type historyEntry struct {
+	ID        string
+	CreatedAt string
+	CreatedBy string
+	Size      string
+	Comment   string
+}
 
-	// Get Image history
-	output, err := exec.Command("docker", "history", "--no-trunc", imageName).Output()
-	if err != nil {
-		return nil, fmt.Errorf("failed to get image history: %w", err)
-	}
+// LoadImage builds a DockerImage from the `docker history` of a local image.
+// It is equivalent to LoadImageHistory.
+func LoadImage(name string) (*DockerImage, error) {
+	return LoadImageHistory(name)
+}
 
-	lines := strings.Split(string(output), "\n")
-	var layers []DockerLayer
-	var totalSize int64
-	var parent *DockerLayer = nil
+// LoadImageContext is like LoadImage but stops docker when the context is done.
+func LoadImageContext(ctx context.Context, name string) (*DockerImage, error) {
+	return LoadImageHistoryContext(ctx, name)
+}
+
+// LoadImageHistory builds a DockerImage from the `docker history` of a local image.
+// See NewDockerImageFromHistory for how layers are assembled.
+func LoadImageHistory(name string) (*DockerImage, error) {
+	return LoadImageHistoryContext(context.Background(), name)
+}
+
+// LoadImageHistoryContext is like LoadImageHistory but stops docker when the context is done.
+func LoadImageHistoryContext(ctx context.Context, name string) (*DockerImage, error) {
+	return defaultClient.LoadImageHistory(ctx, name)
+}
 
-	// Skip the first line because it contains headers
-	for _, line := range lines[1:] {
-		// Skip empty lines and lines with "<missing>" ID.
-		if strings.TrimSpace(line) == "" || strings.Contains(line, "<missing>") {
+// LoadImages loads the history of many local images in parallel. See Client.LoadImages.
+func LoadImages(ctx context.Context, names []string, concurrency int) ([]*DockerImage, error) {
+	return defaultClient.LoadImages(ctx, names, concurrency)
+}
+
+// NewDockerImage builds a DockerImage for a local image from `docker image inspect` and `docker history`.
+// It returns an error wrapping ErrImageNotFound if the image hasn't been pulled or built locally.
+func NewDockerImage(name string) (*DockerImage, error) {
+	return NewDockerImageContext(context.Background(), name)
+}
+
+// NewDockerImageContext is like NewDockerImage but stops docker when the context is done.
+func NewDockerImageContext(ctx context.Context, name string) (*DockerImage, error) {
+	return defaultClient.NewDockerImage(ctx, name)
+}
+
+// NewDockerImageFromHistory builds a DockerImage from the lines printed by
+// `docker history --no-trunc --format '{{json .}}'`, newest layer first.
+// Layers are ordered from the base layer to the top layer, each layer's Parent points
+// to the layer below it, and Size is the sum of the layer sizes. Layers without an ID
+// of their own (those built elsewhere) keep docker's "<missing>" ID.
+// The returned image has no Name.
+func NewDockerImageFromHistory(lines []string) (*DockerImage, error) {
+	var items []HistoryItem
+	for _, line := range lines {
+		if strings.TrimSpace(line) == "" {
 			continue
 		}
 
-		layer, err := NewDockerLayer(line, parent)
+		var entry historyEntry
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			return nil, fmt.Errorf("invalid history entry: %w", err)
+		}
+		size, err := ParseSize(entry.Size)
 		if err != nil {
 			return nil, err
 		}
+		created, err := time.Parse(time.RFC3339, entry.CreatedAt)
+		if err != nil {
+			return nil, fmt.Errorf("invalid creation time: %w", err)
+		}
 
-		layers = append(layers, *layer)
-		totalSize += layer.Size
-		parent = layer
+		items = append(items, HistoryItem{
+			ID:        entry.ID,
+			Created:   created.Unix(),
+			CreatedBy: entry.CreatedBy,
+			Size:      size,
+			Comment:   entry.Comment,
+		})
 	}
+	return newDockerImageFromHistoryItems(items), nil
+}
+
+// newDockerImageFromHistoryItems builds a DockerImage from history items listed newest layer first.
+// Both the docker CLI and the Engine API loaders go through here so they produce identical layers.
+func newDockerImageFromHistoryItems(items []HistoryItem) *DockerImage {
+	var layers []DockerLayer
+	var totalSize int64
+
+	// docker history lists the newest layer first, so walk it backwards to start from the base.
+	for i := len(items) - 1; i >= 0; i-- {
+		item := items[i]
+		layers = append(layers, DockerLayer{
+			ID:        item.ID,
+			Size:      item.Size,
+			Command:   instructionKeyword(item.CreatedBy),
+			Created:   time.Unix(item.Created, 0).UTC(),
+			CreatedBy: item.CreatedBy,
+			Comment:   item.Comment,
+			Tags:      normalizeTags(item.Tags),
+		})
+		totalSize += item.Size
+	}
+	linkLayers(layers)
 
 	image := DockerImage{
-		Name:   imageName,
 		Layers: layers,
 		Size:   totalSize,
 	}
-	return &image, nil
+	return &image
+}
+
+// linkLayers sets the parent of each layer to the layer before it in the slice.
+func linkLayers(layers []DockerLayer) {
+	for i := 1; i < len(layers); i++ {
+		layers[i].Parent = &layers[i-1]
+	}
+}
+
+// Analyze takes a Docker image name and analyzes the image.
+func Analyze(imageName string) (*DockerImage, error) {
+	fmt.Println("Analyzing image: ", imageName)
+	return LoadImage(imageName)
 }
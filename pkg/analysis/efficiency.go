@@ -0,0 +1,197 @@
+package analysis
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// WastedPath is a file whose bytes are stored in more layers than the final image needs.
+type WastedPath struct {
+	Path string
+	// Occurrences is the number of layers that add or replace the file.
+	Occurrences int
+	// WastedBytes is the size of the copies that are overwritten or deleted by later layers.
+	WastedBytes int64
+	// Deleted reports whether the file is removed by a whiteout in a later layer.
+	Deleted bool
+}
+
+// EfficiencyReport describes how much of an image's layer content ends up unused in the final filesystem.
+type EfficiencyReport struct {
+	// TotalBytes is the size of every file in every layer.
+	TotalBytes int64
+	// WastedBytes is the size of the files that are overwritten or deleted by later layers.
+	WastedBytes int64
+	// Score is the share of TotalBytes that isn't wasted, between 0 and 1.
+	Score float64
+	// WastedPaths lists the files with wasted bytes, largest waste first.
+	WastedPaths []WastedPath
+}
+
+// TopWastedPaths returns the n paths with the most wasted bytes.
+func (report *EfficiencyReport) TopWastedPaths(n int) []WastedPath {
+	if n < 0 {
+		n = 0
+	}
+	if n > len(report.WastedPaths) {
+		n = len(report.WastedPaths)
+	}
+	return report.WastedPaths[:n]
+}
+
+// AnalyzeEfficiency reads an image exported with `docker save` (a tarball) or an OCI layout
+// directory and reports the bytes wasted on files that later layers overwrite or delete.
+// Layer tarballs are streamed; nothing is extracted to disk.
+func AnalyzeEfficiency(path string) (*EfficiencyReport, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var layers [][]layerFile
+	if info.IsDir() {
+		manifest, err := readOCIManifest(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read OCI layout: %w", err)
+		}
+		layers, err = readOCILayers(path, manifest)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		manifest, err := readSaveManifest(path)
+		if err != nil {
+			return nil, err
+		}
+		layers, err = readSaveLayers(path, manifest)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return efficiency(layers), nil
+}
+
+// efficiency replays the layers in order and accounts for every file copy that doesn't survive
+// to the final filesystem.
+func efficiency(layers [][]layerFile) *EfficiencyReport {
+	type fileState struct {
+		occurrences int
+		total       int64
+		live        bool
+		liveSize    int64
+	}
+
+	var report EfficiencyReport
+	files := make(map[string]*fileState)
+	live := make(dirIndex)
+	remove := func(path string) {
+		if state, ok := files[path]; ok && state.live {
+			state.live = false
+			state.liveSize = 0
+		}
+	}
+
+	for _, layer := range layers {
+		// Whiteouts only apply to the layers below, so handle them before the layer's own files.
+		for _, file := range layer {
+			if !file.Whiteout {
+				continue
+			}
+			if !file.Opaque {
+				live.unlink(file.Path)
+				remove(file.Path)
+			}
+			live.removeUnder(file.Path, remove)
+		}
+		for _, file := range layer {
+			if file.Whiteout {
+				continue
+			}
+			state, ok := files[file.Path]
+			if !ok {
+				state = &fileState{}
+				files[file.Path] = state
+			}
+			state.occurrences++
+			state.total += file.Size
+			state.live = true
+			live.add(file.Path)
+			state.liveSize = file.Size
+			report.TotalBytes += file.Size
+		}
+	}
+
+	for path, state := range files {
+		wasted := state.total - state.liveSize
+		if wasted == 0 && state.live {
+			continue
+		}
+		report.WastedBytes += wasted
+		report.WastedPaths = append(report.WastedPaths, WastedPath{
+			Path:        path,
+			Occurrences: state.occurrences,
+			WastedBytes: wasted,
+			Deleted:     !state.live,
+		})
+	}
+	sort.Slice(report.WastedPaths, func(i, j int) bool {
+		if report.WastedPaths[i].WastedBytes != report.WastedPaths[j].WastedBytes {
+			return report.WastedPaths[i].WastedBytes > report.WastedPaths[j].WastedBytes
+		}
+		return report.WastedPaths[i].Path < report.WastedPaths[j].Path
+	})
+
+	report.Score = 1
+	if report.TotalBytes > 0 {
+		report.Score = 1 - float64(report.WastedBytes)/float64(report.TotalBytes)
+	}
+	return &report
+}
+
+// dirIndex indexes the live paths of a replayed filesystem by directory, so that a whiteout
+// only visits the paths under the directory it hides. It maps each directory ("" for the root)
+// to its children, files and directories, by full path.
+type dirIndex map[string]map[string]bool
+
+// parentDir returns the directory holding p, "" for the root.
+func parentDir(p string) string {
+	if i := strings.LastIndex(p, "/"); i >= 0 {
+		return p[:i]
+	}
+	return ""
+}
+
+// add records the file p and links its directories up to the root.
+func (idx dirIndex) add(p string) {
+	for p != "" {
+		dir := parentDir(p)
+		children, ok := idx[dir]
+		if !ok {
+			children = make(map[string]bool)
+			idx[dir] = children
+		}
+		if children[p] {
+			// The rest of the chain was linked when p was first added.
+			return
+		}
+		children[p] = true
+		p = dir
+	}
+}
+
+// unlink removes p from the children of its directory.
+func (idx dirIndex) unlink(p string) {
+	delete(idx[parentDir(p)], p)
+}
+
+// removeUnder drops every path under the directory dir from the index, calling fn for each.
+func (idx dirIndex) removeUnder(dir string, fn func(path string)) {
+	children := idx[dir]
+	delete(idx, dir)
+	for child := range children {
+		fn(child)
+		idx.removeUnder(child, fn)
+	}
+}
@@ -0,0 +1,251 @@
+package analysis
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// tarEntry is a file or symlink written by writeTar.
+type tarEntry struct {
+	name     string
+	data     []byte
+	linkname string // makes the entry a symlink
+}
+
+// writeTar returns a tarball holding entries, in order.
+func writeTar(t *testing.T, entries ...tarEntry) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, entry := range entries {
+		header := &tar.Header{Name: entry.name, Mode: 0o644, Size: int64(len(entry.data)), Typeflag: tar.TypeReg}
+		if entry.linkname != "" {
+			header = &tar.Header{Name: entry.name, Mode: 0o777, Linkname: entry.linkname, Typeflag: tar.TypeSymlink}
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write(entry.data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestAnalyzeEfficiencySymlinkedLayer(t *testing.T) {
+	layer := writeTar(t, tarEntry{name: "app/data", data: bytes.Repeat([]byte("x"), 100)})
+	manifest := []byte(`[{"Config":"config.json","RepoTags":["app:1"],"Layers":["a/layer.tar","b/layer.tar"]}]`)
+
+	for _, tc := range []struct {
+		name    string
+		entries []tarEntry
+	}{
+		{"link after target", []tarEntry{
+			{name: "manifest.json", data: manifest},
+			{name: "a/layer.tar", data: layer},
+			{name: "b/layer.tar", linkname: "../a/layer.tar"},
+		}},
+		{"link before target", []tarEntry{
+			{name: "manifest.json", data: manifest},
+			{name: "b/layer.tar", linkname: "../a/layer.tar"},
+			{name: "a/layer.tar", data: layer},
+		}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			archive := filepath.Join(t.TempDir(), "image.tar")
+			if err := os.WriteFile(archive, writeTar(t, tc.entries...), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			report, err := AnalyzeEfficiency(archive)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if report.TotalBytes != 200 || report.WastedBytes != 100 {
+				t.Errorf("got total %d, wasted %d; want total 200, wasted 100", report.TotalBytes, report.WastedBytes)
+			}
+		})
+	}
+}
+
+func TestEfficiencyWhiteouts(t *testing.T) {
+	file := func(path string, size int64) layerFile { return layerFile{Path: path, Size: size} }
+	whiteout := func(path string) layerFile { return layerFile{Path: path, Whiteout: true} }
+	opaque := func(path string) layerFile { return layerFile{Path: path, Whiteout: true, Opaque: true} }
+
+	for _, tc := range []struct {
+		name        string
+		layers      [][]layerFile
+		total       int64
+		wastedPaths []WastedPath
+	}{
+		{
+			"deleted file",
+			[][]layerFile{{file("a/x", 100), file("a/y", 10)}, {whiteout("a/x")}},
+			110,
+			[]WastedPath{{Path: "a/x", Occurrences: 1, WastedBytes: 100, Deleted: true}},
+		},
+		{
+			"deleted directory",
+			[][]layerFile{{file("a/x", 100), file("a/b/c", 50), file("ab/c", 10), file("a", 1)}, {whiteout("a")}},
+			161,
+			[]WastedPath{
+				{Path: "a/x", Occurrences: 1, WastedBytes: 100, Deleted: true},
+				{Path: "a/b/c", Occurrences: 1, WastedBytes: 50, Deleted: true},
+				{Path: "a", Occurrences: 1, WastedBytes: 1, Deleted: true},
+			},
+		},
+		{
+			"opaque directory",
+			[][]layerFile{{file("a/x", 100), file("a/y", 20), file("b", 5)}, {opaque("a"), file("a/y", 30)}},
+			155,
+			[]WastedPath{
+				{Path: "a/x", Occurrences: 1, WastedBytes: 100, Deleted: true},
+				{Path: "a/y", Occurrences: 2, WastedBytes: 20},
+			},
+		},
+		{
+			"opaque root",
+			[][]layerFile{{file("x", 10), file("d/y", 20)}, {opaque(""), file("z", 1)}},
+			31,
+			[]WastedPath{
+				{Path: "d/y", Occurrences: 1, WastedBytes: 20, Deleted: true},
+				{Path: "x", Occurrences: 1, WastedBytes: 10, Deleted: true},
+			},
+		},
+		{
+			"re-added after deletion",
+			[][]layerFile{{file("a/b/x", 100)}, {whiteout("a")}, {file("a/b/x", 40)}, {opaque("a/b")}},
+			140,
+			[]WastedPath{{Path: "a/b/x", Occurrences: 2, WastedBytes: 140, Deleted: true}},
+		},
+		{
+			"whiteout of a missing path",
+			[][]layerFile{{file("a/x", 100)}, {whiteout("b"), opaque("c"), whiteout("a/x/y")}},
+			100,
+			nil,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			report := efficiency(tc.layers)
+			if report.TotalBytes != tc.total {
+				t.Errorf("TotalBytes = %d, want %d", report.TotalBytes, tc.total)
+			}
+			if fmt.Sprint(report.WastedPaths) != fmt.Sprint(tc.wastedPaths) {
+				t.Errorf("WastedPaths = %+v, want %+v", report.WastedPaths, tc.wastedPaths)
+			}
+			var wasted int64
+			for _, path := range tc.wastedPaths {
+				wasted += path.WastedBytes
+			}
+			if report.WastedBytes != wasted {
+				t.Errorf("WastedBytes = %d, want %d", report.WastedBytes, wasted)
+			}
+		})
+	}
+}
+
+func TestAnalyzeEfficiencyLayerFormats(t *testing.T) {
+	base := writeTar(t,
+		tarEntry{name: "etc/config", data: bytes.Repeat([]byte("c"), 40)},
+		tarEntry{name: "app/cache/a", data: bytes.Repeat([]byte("a"), 100)},
+		tarEntry{name: "app/cache/b", data: bytes.Repeat([]byte("b"), 60)},
+		tarEntry{name: "app/bin", data: bytes.Repeat([]byte("x"), 10)},
+	)
+	top := writeTar(t,
+		tarEntry{name: "etc/.wh.config"},
+		tarEntry{name: "app/cache/.wh..wh..opq"},
+		tarEntry{name: "app/bin", data: bytes.Repeat([]byte("y"), 20)},
+	)
+	var gzipped bytes.Buffer
+	zw := gzip.NewWriter(&gzipped)
+	zw.Write(top)
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name string
+		path string
+	}{
+		{"docker save", writeSaveArchive(t, []byte(`{}`), `[]`, base, top)},
+		{"OCI layout", writeOCILayout(t, []byte(`{}`), nil, base, gzipped.Bytes())},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			report, err := AnalyzeEfficiency(tc.path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := []WastedPath{
+				{Path: "app/cache/a", Occurrences: 1, WastedBytes: 100, Deleted: true},
+				{Path: "app/cache/b", Occurrences: 1, WastedBytes: 60, Deleted: true},
+				{Path: "etc/config", Occurrences: 1, WastedBytes: 40, Deleted: true},
+				{Path: "app/bin", Occurrences: 2, WastedBytes: 10},
+			}
+			if report.TotalBytes != 230 || report.WastedBytes != 210 {
+				t.Errorf("got total %d, wasted %d; want total 230, wasted 210", report.TotalBytes, report.WastedBytes)
+			}
+			if fmt.Sprint(report.WastedPaths) != fmt.Sprint(want) {
+				t.Errorf("WastedPaths = %+v, want %+v", report.WastedPaths, want)
+			}
+		})
+	}
+}
+
+// nestOCIIndex wraps the index.json of an OCI layout directory in n more image indexes.
+func nestOCIIndex(t *testing.T, dir string, n int) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		index, err := os.ReadFile(filepath.Join(dir, "index.json"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		digest := sha256Digest(index)
+		blob, _ := ociBlobPath(dir, digest)
+		if err := os.WriteFile(blob, index, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		outer, _ := json.Marshal(ociManifest{Manifests: []ociDescriptor{{Digest: digest, Size: int64(len(index))}}})
+		if err := os.WriteFile(filepath.Join(dir, "index.json"), outer, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestReadOCIManifestNestedIndexes(t *testing.T) {
+	layer := writeTar(t, tarEntry{name: "app", data: []byte("binary")})
+	for _, tc := range []struct {
+		nested  int
+		wantErr bool
+	}{
+		{0, false},
+		{maxOCIIndexDepth - 1, false},
+		{maxOCIIndexDepth, true},
+	} {
+		t.Run(fmt.Sprint(tc.nested), func(t *testing.T) {
+			dir := writeOCILayout(t, singleLayerConfig, nil, layer)
+			nestOCIIndex(t, dir, tc.nested)
+			manifest, err := readOCIManifest(dir)
+			if tc.wantErr {
+				if err == nil {
+					t.Fatal("got no error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(manifest.Layers) != 1 {
+				t.Errorf("got %d layers, want 1", len(manifest.Layers))
+			}
+		})
+	}
+}
@@ -0,0 +1,140 @@
+package analysis
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// LayerFilter reports whether a layer should be kept. Filters can be combined with And, Or and Not.
+type LayerFilter func(layer DockerLayer) bool
+
+// FilterLayers returns the layers accepted by f, in order, or nil when it accepts none.
+func FilterLayers(layers []DockerLayer, f LayerFilter) []DockerLayer {
+	var result []DockerLayer
+	for _, layer := range layers {
+		if f(layer) {
+			result = append(result, layer)
+		}
+	}
+	return result
+}
+
+// And accepts layers accepted by every filter. With no filters it accepts every layer.
+func And(filters ...LayerFilter) LayerFilter {
+	return func(layer DockerLayer) bool {
+		for _, f := range filters {
+			if !f(layer) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// Or accepts layers accepted by any filter. With no filters it accepts no layer.
+func Or(filters ...LayerFilter) LayerFilter {
+	return func(layer DockerLayer) bool {
+		for _, f := range filters {
+			if f(layer) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// Not accepts the layers f rejects.
+func Not(f LayerFilter) LayerFilter {
+	return func(layer DockerLayer) bool {
+		return !f(layer)
+	}
+}
+
+// ByAuthor accepts layers created by author.
+func ByAuthor(author string) LayerFilter {
+	return func(layer DockerLayer) bool {
+		return layer.Author == author
+	}
+}
+
+// ByCommand accepts layers whose Command is exactly command.
+func ByCommand(command string) LayerFilter {
+	return func(layer DockerLayer) bool {
+		return layer.Command == command
+	}
+}
+
+// ByCommandContains accepts layers whose Command or CreatedBy contains s.
+func ByCommandContains(s string) LayerFilter {
+	return func(layer DockerLayer) bool {
+		return strings.Contains(layer.Command, s) || strings.Contains(layer.CreatedBy, s)
+	}
+}
+
+// ByCommandRegexp accepts layers whose Command or CreatedBy matches pattern.
+// It returns an error if pattern isn't a valid regular expression.
+func ByCommandRegexp(pattern string) (LayerFilter, error) {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid command pattern: %w", err)
+	}
+	return func(layer DockerLayer) bool {
+		return re.MatchString(layer.Command) || re.MatchString(layer.CreatedBy)
+	}, nil
+}
+
+// MinSize accepts layers of at least size bytes.
+func MinSize(size int64) LayerFilter {
+	return func(layer DockerLayer) bool {
+		return layer.Size >= size
+	}
+}
+
+// MaxSize accepts layers of at most size bytes.
+func MaxSize(size int64) LayerFilter {
+	return func(layer DockerLayer) bool {
+		return layer.Size <= size
+	}
+}
+
+// CreatedAfter accepts layers created strictly after t.
+func CreatedAfter(t time.Time) LayerFilter {
+	return func(layer DockerLayer) bool {
+		return layer.Created.After(t)
+	}
+}
+
+// CreatedBefore accepts layers created strictly before t.
+func CreatedBefore(t time.Time) LayerFilter {
+	return func(layer DockerLayer) bool {
+		return layer.Created.Before(t)
+	}
+}
+
+// HasTag accepts layers carrying tag.
+func HasTag(tag string) LayerFilter {
+	return func(layer DockerLayer) bool {
+		for _, t := range layer.Tags {
+			if t == tag {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// TagCountAbove accepts layers with more than count tags.
+func TagCountAbove(count int) LayerFilter {
+	return func(layer DockerLayer) bool {
+		return len(layer.Tags) > count
+	}
+}
+
+// TagCountBelow accepts layers with fewer than count tags.
+func TagCountBelow(count int) LayerFilter {
+	return func(layer DockerLayer) bool {
+		return len(layer.Tags) < count
+	}
+}
@@ -0,0 +1,149 @@
+package analysis
+
+import (
+	"context"
+	"sort"
+)
+
+// fleetTopN is the number of commands and layers listed in a FleetReport.
+const fleetTopN = 10
+
+// FleetImage is the summary of one image in a FleetReport.
+type FleetImage struct {
+	Name   string
+	Size   int64 // total size of the image's layers, in bytes
+	Layers int
+	// Err is why the image couldn't be loaded; the other fields are zero when it is set.
+	Err error
+}
+
+// FleetLayer is a distinct layer of a fleet and the images that contain it.
+type FleetLayer struct {
+	// ID is the diff ID of the layer, or its ID from docker history if the image's history
+	// doesn't line up with its RootFS.
+	ID     string
+	Size   int64
+	Images []string
+}
+
+// FleetReport aggregates the analysis of many images.
+type FleetReport struct {
+	// Images lists every requested image in the order given, including those that failed to load.
+	Images []FleetImage
+	// TotalBytes is the sum of the sizes of the loaded images.
+	TotalBytes int64
+	// UniqueBytes is the storage the loaded images take when every distinct layer is stored once.
+	UniqueBytes int64
+	// SharedSavings is TotalBytes minus UniqueBytes.
+	SharedSavings int64
+	// SharedLayers lists the layers found in more than one image, those saving the most bytes first.
+	SharedLayers []FleetLayer
+	// MostCommonCommands lists the commands that created the most layers across the fleet.
+	MostCommonCommands []ValueCount
+	// LargestLayers lists the largest distinct layers across the fleet.
+	LargestLayers []FleetLayer
+}
+
+// Failed returns the images that couldn't be loaded.
+func (report *FleetReport) Failed() []FleetImage {
+	var failed []FleetImage
+	for _, image := range report.Images {
+		if image.Err != nil {
+			failed = append(failed, image)
+		}
+	}
+	return failed
+}
+
+// AnalyzeImages loads many local images through the default client and aggregates them into a
+// FleetReport. See Client.AnalyzeImages.
+func AnalyzeImages(ctx context.Context, names []string, concurrency int) (*FleetReport, error) {
+	return defaultClient.AnalyzeImages(ctx, names, concurrency)
+}
+
+// AnalyzeImages loads many local images, running up to concurrency images at a time, and
+// aggregates them into a FleetReport. Layers are matched across images by the diff IDs of the
+// images' RootFS, which `docker image inspect` reports even for pulled layers that docker
+// history lists as "<missing>". Images that fail to load are reported with their error instead
+// of aborting the run. If the context is done, docker commands in flight are stopped and the
+// report of the images loaded so far is returned with the context's error.
+func (c *Client) AnalyzeImages(ctx context.Context, names []string, concurrency int) (*FleetReport, error) {
+	images := make([]*DockerImage, len(names))
+	errs := c.loadEach(ctx, names, concurrency, func(ctx context.Context, i int, name string) error {
+		inspect, err := c.Inspect(ctx, name)
+		if err != nil {
+			return err
+		}
+		image, err := c.LoadImageHistory(ctx, name)
+		if err != nil {
+			return err
+		}
+		useDiffIDs(image, inspect.RootFS.Layers)
+		images[i] = image
+		return nil
+	})
+
+	report := &FleetReport{Images: make([]FleetImage, len(names))}
+	var loaded []*DockerImage
+	for i, name := range names {
+		if errs[i] != nil {
+			report.Images[i] = FleetImage{Name: name, Err: errs[i]}
+			continue
+		}
+		size := TotalSize(images[i].Layers)
+		report.Images[i] = FleetImage{Name: name, Size: size, Layers: len(images[i].Layers)}
+		report.TotalBytes += size
+		loaded = append(loaded, images[i])
+	}
+
+	var allLayers []DockerLayer
+	var distinct []FleetLayer
+	for _, image := range loaded {
+		allLayers = append(allLayers, image.Layers...)
+	}
+	for _, shared := range collectSharedLayers(loaded) {
+		layer := FleetLayer{ID: shared.layer.ID, Size: shared.layer.Size}
+		for _, holder := range shared.holders {
+			layer.Images = append(layer.Images, holder.Name)
+		}
+		distinct = append(distinct, layer)
+		report.UniqueBytes += layer.Size
+		if len(layer.Images) > 1 && layer.Size > 0 {
+			report.SharedLayers = append(report.SharedLayers, layer)
+		}
+	}
+	report.SharedSavings = report.TotalBytes - report.UniqueBytes
+
+	sort.SliceStable(report.SharedLayers, func(i, j int) bool {
+		a, b := report.SharedLayers[i], report.SharedLayers[j]
+		return a.Size*int64(len(a.Images)-1) > b.Size*int64(len(b.Images)-1)
+	})
+	sort.SliceStable(distinct, func(i, j int) bool {
+		return distinct[i].Size > distinct[j].Size
+	})
+	if len(distinct) > fleetTopN {
+		distinct = distinct[:fleetTopN]
+	}
+	report.LargestLayers = distinct
+	report.MostCommonCommands = MostCommonCommandsWithCounts(allLayers, fleetTopN)
+
+	return report, ctx.Err()
+}
+
+// useDiffIDs replaces the IDs of the layers of image that have content, those with a size,
+// with the diff IDs of its RootFS, oldest first. Layers without content keep their ID. The
+// image is left as is if the number of layers with content doesn't match the RootFS.
+func useDiffIDs(image *DockerImage, diffIDs []string) {
+	var content []int
+	for i, layer := range image.Layers {
+		if layer.Size > 0 {
+			content = append(content, i)
+		}
+	}
+	if len(content) != len(diffIDs) {
+		return
+	}
+	for n, i := range content {
+		image.Layers[i].ID = diffIDs[n]
+	}
+}
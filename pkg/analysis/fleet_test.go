@@ -0,0 +1,145 @@
+package analysis
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// fleetLayer is a layer of an image served by fleetRunner.
+type fleetLayer struct {
+	id        string // ID reported by docker history
+	createdBy string
+	size      int64
+}
+
+// fleetFixture is an image served by fleetRunner: its layers base first and its RootFS diff IDs.
+type fleetFixture struct {
+	layers  []fleetLayer
+	diffIDs []string
+}
+
+// fleetRunner answers docker history and docker image inspect for the images in fixtures.
+// Commands for other images fail like docker does for a missing image.
+func fleetRunner(fixtures map[string]fleetFixture) Runner {
+	return RunnerFunc(func(ctx context.Context, name string, args ...string) ([]byte, error) {
+		image := args[len(args)-1]
+		fixture, ok := fixtures[image]
+		if !ok {
+			return nil, errors.New("Error response from daemon: No such image: " + image + ": exit status 1")
+		}
+		if args[0] == "image" {
+			return json.Marshal([]ImageInspect{{ID: "sha256:" + image, RootFS: RootFS{Type: "layers", Layers: fixture.diffIDs}}})
+		}
+		var lines []string
+		for i := len(fixture.layers) - 1; i >= 0; i-- {
+			layer := fixture.layers[i]
+			lines = append(lines, fmt.Sprintf(`{"ID":%q,"CreatedAt":"2023-01-01T00:00:00Z","CreatedBy":%q,"Size":"%d","Tags":"","Comment":""}`,
+				layer.id, layer.createdBy, layer.size))
+		}
+		return []byte(strings.Join(lines, "\n")), nil
+	})
+}
+
+// fleetIDs returns the IDs of layers, and the images holding each, as "id:image,image".
+func fleetIDs(layers []FleetLayer) []string {
+	var ids []string
+	for _, layer := range layers {
+		ids = append(ids, layer.ID+":"+strings.Join(layer.Images, ","))
+	}
+	return ids
+}
+
+func TestAnalyzeImages(t *testing.T) {
+	base := []fleetLayer{{"<missing>", "ADD rootfs.tar /", 100}, {"<missing>", "CMD [\"bash\"]", 0}}
+	python := append(base[:2:2], fleetLayer{"<missing>", "RUN apt-get install python3", 50}, fleetLayer{"<missing>", "RUN pip install flask", 30})
+	client := NewClient(WithRunner(fleetRunner(map[string]fleetFixture{
+		"debian:12":   {append(base[:1:1], fleetLayer{"sha256:debian", "CMD [\"bash\"]", 0}), []string{"sha256:d1"}},
+		"python:3.12": {python, []string{"sha256:d1", "sha256:p1", "sha256:p2"}},
+		"app:1":       {append(python[:4:4], fleetLayer{"sha256:app", "COPY . /app", 20}), []string{"sha256:d1", "sha256:p1", "sha256:p2", "sha256:a1"}},
+		// The RootFS doesn't line up with the history, so layers keep their history IDs.
+		"odd:1": {[]fleetLayer{{"<missing>", "ADD rootfs.tar /", 100}, {"sha256:odd", "RUN make", 10}}, []string{"sha256:d1"}},
+	})))
+
+	names := []string{"debian:12", "python:3.12", "missing:1", "app:1", "odd:1"}
+	report, err := client.AnalyzeImages(context.Background(), names, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, want := range []FleetImage{
+		{Name: "debian:12", Size: 100, Layers: 2},
+		{Name: "python:3.12", Size: 180, Layers: 4},
+		{Name: "missing:1"},
+		{Name: "app:1", Size: 200, Layers: 5},
+		{Name: "odd:1", Size: 110, Layers: 2},
+	} {
+		got := report.Images[i]
+		if got.Name != want.Name || got.Size != want.Size || got.Layers != want.Layers {
+			t.Errorf("image %d: got %+v, want %+v", i, got, want)
+		}
+		if (got.Err != nil) != (want.Name == "missing:1") {
+			t.Errorf("image %d: got error %v", i, got.Err)
+		}
+	}
+	if failed := report.Failed(); len(failed) != 1 || !errors.Is(failed[0].Err, ErrImageNotFound) || !strings.Contains(failed[0].Err.Error(), "missing:1") {
+		t.Errorf("Failed() = %+v, want missing:1 with ErrImageNotFound", failed)
+	}
+
+	if report.TotalBytes != 590 || report.UniqueBytes != 310 || report.SharedSavings != 280 {
+		t.Errorf("got total %d, unique %d, savings %d; want 590, 310, 280", report.TotalBytes, report.UniqueBytes, report.SharedSavings)
+	}
+	wantShared := []string{
+		"sha256:d1:debian:12,python:3.12,app:1",
+		"sha256:p1:python:3.12,app:1",
+		"sha256:p2:python:3.12,app:1",
+	}
+	if got := fleetIDs(report.SharedLayers); fmt.Sprint(got) != fmt.Sprint(wantShared) {
+		t.Errorf("SharedLayers = %q, want %q", got, wantShared)
+	}
+	wantLargest := []string{
+		"sha256:d1:debian:12,python:3.12,app:1",
+		"<missing>:odd:1",
+		"sha256:p1:python:3.12,app:1",
+		"sha256:p2:python:3.12,app:1",
+		"sha256:a1:app:1",
+		"sha256:odd:odd:1",
+	}
+	if got := fleetIDs(report.LargestLayers); fmt.Sprint(got[:len(wantLargest)]) != fmt.Sprint(wantLargest) {
+		t.Errorf("LargestLayers = %q, want %q first", got, wantLargest)
+	}
+}
+
+func TestAnalyzeImagesCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	fixtures := fleetRunner(map[string]fleetFixture{
+		"a:1": {[]fleetLayer{{"sha256:a", "ADD a /", 10}}, []string{"sha256:a"}},
+		"b:1": {[]fleetLayer{{"sha256:b", "ADD b /", 20}}, []string{"sha256:b"}},
+	})
+	client := NewClient(WithRunner(RunnerFunc(func(ctx context.Context, name string, args ...string) ([]byte, error) {
+		if args[len(args)-1] == "b:1" {
+			// Cancel while loading the second image and wait for the command to be stopped.
+			cancel()
+			<-ctx.Done()
+			return nil, errors.New("signal: killed")
+		}
+		return fixtures.Run(ctx, name, args...)
+	})))
+
+	report, err := client.AnalyzeImages(ctx, []string{"a:1", "b:1", "c:1"}, 1)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("got error %v, want context.Canceled", err)
+	}
+	if report.Images[0].Err != nil || report.TotalBytes != 10 {
+		t.Errorf("got %+v with %d bytes, want a:1 loaded", report.Images[0], report.TotalBytes)
+	}
+	for _, image := range report.Images[1:] {
+		if !errors.Is(image.Err, context.Canceled) {
+			t.Errorf("%s: got error %v, want context.Canceled", image.Name, image.Err)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package analysis
+
+import "time"
+
+// imageConfig is the part of an image config blob needed to rebuild the layer history.
+type imageConfig struct {
+	Architecture string          `json:"architecture"`
+	OS           string          `json:"os"`
+	Variant      string          `json:"variant"`
+	Created      time.Time       `json:"created"`
+	History      []configHistory `json:"history"`
+	RootFS       struct {
+		DiffIDs []string `json:"diff_ids"`
+	} `json:"rootfs"`
+}
+
+// configHistory is an entry of the history of an image config, oldest first.
+type configHistory struct {
+	Created    time.Time `json:"created"`
+	CreatedBy  string    `json:"created_by"`
+	Author     string    `json:"author"`
+	Comment    string    `json:"comment"`
+	EmptyLayer bool      `json:"empty_layer"`
+}
+
+// newDockerImageFromConfig builds a DockerImage from an image config's history and the layer
+// descriptors of its manifest, both oldest first. History entries marked as empty layers (ENV,
+// LABEL, CMD, ...) become zero-size layers with the "<missing>" ID; the others take the digest
+// and size of the next layer descriptor. Layers without a history entry are kept with an empty
+// CreatedBy so that sizes still add up.
+func newDockerImageFromConfig(config imageConfig, descriptors []ociDescriptor) *DockerImage {
+	var layers []DockerLayer
+	var totalSize int64
+	next := 0
+	for _, entry := range config.History {
+		layer := DockerLayer{
+			ID:        "<missing>",
+			Command:   instructionKeyword(entry.CreatedBy),
+			Author:    entry.Author,
+			Created:   entry.Created.UTC(),
+			CreatedBy: entry.CreatedBy,
+			Comment:   entry.Comment,
+		}
+		if !entry.EmptyLayer && next < len(descriptors) {
+			layer.ID = descriptors[next].Digest
+			layer.Size = descriptors[next].Size
+			next++
+		}
+		layers = append(layers, layer)
+		totalSize += layer.Size
+	}
+	for _, descriptor := range descriptors[next:] {
+		layers = append(layers, DockerLayer{
+			ID:      descriptor.Digest,
+			Size:    descriptor.Size,
+			Created: config.Created.UTC(),
+		})
+		totalSize += descriptor.Size
+	}
+	linkLayers(layers)
+
+	image := DockerImage{
+		Layers: layers,
+		Size:   totalSize,
+	}
+	return &image
+}
@@ -0,0 +1,73 @@
+package analysis
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// ImageConfig holds the runtime configuration of a docker image.
+type ImageConfig struct {
+	User         string
+	Env          []string
+	Entrypoint   []string
+	Cmd          []string
+	WorkingDir   string
+	Labels       map[string]string
+	ExposedPorts map[string]struct{}
+}
+
+// RootFS holds the layer digests that make up a docker image's filesystem.
+type RootFS struct {
+	Type   string
+	Layers []string
+}
+
+// ImageInspect holds the information returned by `docker image inspect`.
+type ImageInspect struct {
+	ID           string
+	RepoTags     []string
+	RepoDigests  []string
+	Author       string
+	Created      time.Time
+	Architecture string
+	Variant      string
+	Os           string
+	Size         int64 // in bytes
+	Config       ImageConfig
+	RootFS       RootFS
+}
+
+// UnmarshalJSON decodes inspect output, tolerating an empty or missing creation time.
+func (inspect *ImageInspect) UnmarshalJSON(data []byte) error {
+	type plain ImageInspect
+	aux := struct {
+		*plain
+		Created string
+	}{plain: (*plain)(inspect)}
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+
+	inspect.Created = time.Time{}
+	if aux.Created != "" {
+		created, err := time.Parse(time.RFC3339Nano, aux.Created)
+		if err != nil {
+			return fmt.Errorf("invalid creation time: %w", err)
+		}
+		inspect.Created = created
+	}
+	return nil
+}
+
+// parseInspect parses the JSON array printed by `docker image inspect` for a single image.
+func parseInspect(name string, output []byte) (*ImageInspect, error) {
+	var inspectOutput []ImageInspect
+	if err := json.Unmarshal(output, &inspectOutput); err != nil {
+		return nil, fmt.Errorf("failed to parse inspect output: %w", err)
+	}
+	if len(inspectOutput) == 0 {
+		return nil, fmt.Errorf("%w: %s", ErrImageNotFound, name)
+	}
+	return &inspectOutput[0], nil
+}
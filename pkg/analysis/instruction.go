@@ -0,0 +1,89 @@
+package analysis
+
+import (
+	"regexp"
+	"strings"
+)
+
+// dockerfileInstructions holds the Dockerfile instructions that can show up in image history.
+var dockerfileInstructions = map[string]struct{}{
+	"ADD":         {},
+	"ARG":         {},
+	"CMD":         {},
+	"COPY":        {},
+	"ENTRYPOINT":  {},
+	"ENV":         {},
+	"EXPOSE":      {},
+	"HEALTHCHECK": {},
+	"LABEL":       {},
+	"MAINTAINER":  {},
+	"ONBUILD":     {},
+	"RUN":         {},
+	"SHELL":       {},
+	"STOPSIGNAL":  {},
+	"USER":        {},
+	"VOLUME":      {},
+	"WORKDIR":     {},
+}
+
+var (
+	// Buildkit prefixes RUN steps that see build args with "|<n> KEY=value ...".
+	buildArgsPrefix = regexp.MustCompile(`^\|\d+(\s+[^\s=]+=\S*)*\s+`)
+	hexDigest       = regexp.MustCompile(`[0-9a-f]{32,}`)
+)
+
+// splitInstruction returns the leading Dockerfile instruction of s, if any, and the rest of s.
+func splitInstruction(s string) (string, string) {
+	fields := strings.Fields(s)
+	if len(fields) == 0 {
+		return "", s
+	}
+	if _, ok := dockerfileInstructions[fields[0]]; !ok {
+		return "", s
+	}
+	return fields[0], strings.TrimSpace(strings.TrimPrefix(s, fields[0]))
+}
+
+// normalizeInstruction turns a CreatedBy value from either legacy or buildkit history
+// into a "KEYWORD arguments" string such as "RUN apt-get update".
+// Values that can't be recognized are returned with their whitespace collapsed.
+func normalizeInstruction(createdBy string) string {
+	s := strings.TrimSpace(createdBy)
+	s = strings.TrimSpace(strings.TrimSuffix(s, "# buildkit"))
+
+	keyword, s := splitInstruction(s)
+	s = buildArgsPrefix.ReplaceAllString(s, "")
+
+	if strings.HasPrefix(s, "/bin/sh -c ") {
+		s = strings.TrimSpace(strings.TrimPrefix(s, "/bin/sh -c "))
+		if keyword == "" {
+			keyword = "RUN"
+		}
+	}
+	// The legacy builder records non-RUN instructions as "/bin/sh -c #(nop) CMD ...".
+	if strings.HasPrefix(s, "#(nop)") {
+		keyword, s = splitInstruction(strings.TrimSpace(strings.TrimPrefix(s, "#(nop)")))
+	}
+
+	s = strings.Join(strings.Fields(s), " ")
+	if keyword == "" {
+		return s
+	}
+	if s == "" {
+		return keyword
+	}
+	return keyword + " " + s
+}
+
+// instructionKeyword returns the Dockerfile instruction (RUN, COPY, ...) that created a layer,
+// or an empty string if it can't be determined.
+func instructionKeyword(createdBy string) string {
+	keyword, _ := splitInstruction(normalizeInstruction(createdBy))
+	return keyword
+}
+
+// instructionTemplate normalizes a CreatedBy value and replaces digests in it,
+// so layers built by the same Dockerfile line in different builds share a template.
+func instructionTemplate(createdBy string) string {
+	return hexDigest.ReplaceAllString(normalizeInstruction(createdBy), "<digest>")
+}
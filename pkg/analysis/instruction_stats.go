@@ -0,0 +1,68 @@
+package analysis
+
+import "sort"
+
+// unknownInstruction is the bucket for layers whose CreatedBy doesn't name a Dockerfile instruction.
+const unknownInstruction = "unknown"
+
+// InstructionStat summarizes the layers created by one kind of Dockerfile instruction.
+type InstructionStat struct {
+	Instruction string
+	Count       int
+	Size        int64   // in bytes
+	Percent     float64 // share of the image size, from 0 to 100
+}
+
+// layerInstruction returns the Dockerfile instruction that created a layer, or "unknown".
+func layerInstruction(layer DockerLayer) string {
+	if keyword := instructionKeyword(layer.CreatedBy); keyword != "" {
+		return keyword
+	}
+	return unknownInstruction
+}
+
+// AttributeSizeByInstruction returns the total size of the layers created by each Dockerfile
+// instruction (RUN, COPY, ADD, ...), read from CreatedBy in either legacy or buildkit form.
+// Layers whose instruction can't be determined are counted under "unknown".
+func AttributeSizeByInstruction(layers []DockerLayer) map[string]int64 {
+	result := make(map[string]int64)
+	for _, layer := range layers {
+		result[layerInstruction(layer)] += layer.Size
+	}
+	return result
+}
+
+// InstructionBreakdown returns the number of layers, total size and share of the image size of
+// each Dockerfile instruction in the image, largest first.
+func InstructionBreakdown(image *DockerImage) []InstructionStat {
+	index := make(map[string]int)
+	var stats []InstructionStat
+	for _, layer := range image.Layers {
+		instruction := layerInstruction(layer)
+		i, ok := index[instruction]
+		if !ok {
+			i = len(stats)
+			index[instruction] = i
+			stats = append(stats, InstructionStat{Instruction: instruction})
+		}
+		stats[i].Count++
+		stats[i].Size += layer.Size
+	}
+
+	total := image.Size
+	if total == 0 {
+		total = TotalSize(image.Layers)
+	}
+	for i := range stats {
+		if total > 0 {
+			stats[i].Percent = float64(stats[i].Size) / float64(total) * 100
+		}
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].Size != stats[j].Size {
+			return stats[i].Size > stats[j].Size
+		}
+		return stats[i].Instruction < stats[j].Instruction
+	})
+	return stats
+}
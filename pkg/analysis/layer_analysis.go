@@ -1,68 +1,117 @@
 package analysis
 
 import (
+	"fmt"
+	"math"
 	"sort"
 	"time"
 )
 
-// A general function for getting the most common elements
-func mostCommon(mapWithCount map[string]int, n int) []string {
-	type frequency struct {
-		Value string
-		Count int
+// ValueCount is a value along with the number of times it occurs.
+type ValueCount struct {
+	Value string
+	Count int
+}
+
+// String returns the value with its count, e.g. "apt-get (5 layers)".
+func (vc ValueCount) String() string {
+	if vc.Count == 1 {
+		return fmt.Sprintf("%s (1 layer)", vc.Value)
 	}
+	return fmt.Sprintf("%s (%d layers)", vc.Value, vc.Count)
+}
 
-	frequencies := make([]frequency, 0, len(mapWithCount))
+// A general function for getting the most common elements with their counts.
+// It returns at most n values, fewer when there aren't that many distinct values.
+func mostCommonWithCounts(mapWithCount map[string]int, n int) []ValueCount {
+	frequencies := make([]ValueCount, 0, len(mapWithCount))
 	for value, count := range mapWithCount {
-		frequencies = append(frequencies, frequency{Value: value, Count: count})
+		frequencies = append(frequencies, ValueCount{Value: value, Count: count})
 	}
 
+	// Break ties alphabetically so the result doesn't depend on map iteration order.
 	sort.Slice(frequencies, func(i, j int) bool {
-		return frequencies[i].Count > frequencies[j].Count
+		if frequencies[i].Count != frequencies[j].Count {
+			return frequencies[i].Count > frequencies[j].Count
+		}
+		return frequencies[i].Value < frequencies[j].Value
 	})
 
-	values := make([]string, n)
-	for i := 0; i < n && i < len(frequencies); i++ {
-		values[i] = frequencies[i].Value
+	if n < 0 {
+		n = 0
+	}
+	if n > len(frequencies) {
+		n = len(frequencies)
+	}
+	return frequencies[:n]
+}
+
+// valuesOf returns the values of counts, in order.
+func valuesOf(counts []ValueCount) []string {
+	values := make([]string, len(counts))
+	for i, count := range counts {
+		values[i] = count.Value
 	}
 	return values
 }
 
 // MostCommonCommands returns the most common commands used to create layers
 func MostCommonCommands(layers []DockerLayer, n int) []string {
+	return valuesOf(MostCommonCommandsWithCounts(layers, n))
+}
+
+// MostCommonCommandsWithCounts returns the most common commands used to create layers along with the number of layers each created.
+func MostCommonCommandsWithCounts(layers []DockerLayer, n int) []ValueCount {
 	commandFrequency := make(map[string]int)
 	for _, layer := range layers {
 		commandFrequency[layer.Command]++
 	}
-	return mostCommon(commandFrequency, n)
+	return mostCommonWithCounts(commandFrequency, n)
 }
 
 // MostProlificAuthors returns the authors who created the most layers.
 func MostProlificAuthors(layers []DockerLayer, n int) []string {
-	authorFrequency := make(map[string]int)
-	for _, layer := range layers {
-		authorFrequency[layer.Author]++
-	}
-	return mostCommon(authorFrequency, n)
+	return valuesOf(MostProlificAuthorsWithCounts(layers, n))
+}
+
+// MostProlificAuthorsWithCounts returns the authors who created the most layers along with the number of layers each created.
+func MostProlificAuthorsWithCounts(layers []DockerLayer, n int) []ValueCount {
+	return mostCommonWithCounts(LayerCountByAuthor(layers), n)
 }
 
 // MostCommonTags returns the most common tags.
 func MostCommonTags(layers []DockerLayer, n int) []string {
+	return valuesOf(MostCommonTagsWithCounts(layers, n))
+}
+
+// MostCommonTagsWithCounts returns the most common tags along with the number of layers carrying each.
+func MostCommonTagsWithCounts(layers []DockerLayer, n int) []ValueCount {
 	tagFrequency := make(map[string]int)
 	for _, layer := range layers {
 		for _, tag := range layer.Tags {
 			tagFrequency[tag]++
 		}
 	}
-	return mostCommon(tagFrequency, n)
+	return mostCommonWithCounts(tagFrequency, n)
 }
 
-// General function for sorting layers
+// General function for sorting layers.
+// Layers that compare equal are ordered by ID, then by their original position, so results are reproducible.
+// At most n layers are returned; a negative n returns none.
 func sortLayers(layers []DockerLayer, comparison func(layer1, layer2 DockerLayer) bool, n int) []DockerLayer {
 	copiedLayers := append([]DockerLayer(nil), layers...)
-	sort.Slice(copiedLayers, func(i, j int) bool {
-		return comparison(copiedLayers[i], copiedLayers[j])
+	sort.SliceStable(copiedLayers, func(i, j int) bool {
+		if comparison(copiedLayers[i], copiedLayers[j]) {
+			return true
+		}
+		if comparison(copiedLayers[j], copiedLayers[i]) {
+			return false
+		}
+		return copiedLayers[i].ID < copiedLayers[j].ID
 	})
+	if n < 0 {
+		n = 0
+	}
 	if n > len(copiedLayers) {
 		n = len(copiedLayers)
 	}
@@ -106,51 +155,174 @@ func LayerSizeDistribution(layers []DockerLayer) map[int64]int {
 	return distribution
 }
 
-// LayersInDateRange returns all layers created in a specific date range.
-func LayersInDateRange(layers []DockerLayer, start, end time.Time) []DockerLayer {
-	var result []DockerLayer
+// SizeHistogram groups layers into size ranges of bucketSize bytes and counts them,
+// keyed by the lower bound of each range.
+func SizeHistogram(layers []DockerLayer, bucketSize int64) (map[int64]int, error) {
+	if bucketSize <= 0 {
+		return nil, fmt.Errorf("bucket size must be positive: %d", bucketSize)
+	}
+	histogram := make(map[int64]int)
 	for _, layer := range layers {
-		if layer.Created.After(start) && layer.Created.Before(end) {
-			result = append(result, layer)
+		histogram[layer.Size-layer.Size%bucketSize]++
+	}
+	return histogram, nil
+}
+
+// DefaultSizeBuckets returns the bucket boundaries 1 MB, 10 MB and 100 MB, for use with LayerSizeHistogram.
+func DefaultSizeBuckets() []int64 {
+	return []int64{1e6, 10e6, 100e6}
+}
+
+// sizeBucketLabels returns the labels of the buckets delimited by the sorted, distinct boundaries:
+// "< b0", "b0 - b1", ..., ">= bn". Sizes are formatted with FormatSize, or in bytes if
+// rounding would make two boundaries look the same.
+func sizeBucketLabels(boundaries []int64) []string {
+	format := FormatSize
+	seen := make(map[string]bool)
+	for _, boundary := range boundaries {
+		if seen[FormatSize(boundary)] {
+			format = func(size int64) string { return fmt.Sprintf("%d B", size) }
+			break
 		}
+		seen[FormatSize(boundary)] = true
 	}
-	return result
+
+	labels := make([]string, 0, len(boundaries)+1)
+	labels = append(labels, "< "+format(boundaries[0]))
+	for i := 1; i < len(boundaries); i++ {
+		labels = append(labels, format(boundaries[i-1])+" - "+format(boundaries[i]))
+	}
+	return append(labels, ">= "+format(boundaries[len(boundaries)-1]))
 }
 
-// LayersWithTags returns all layers that have one or more tags.
-func LayersWithTags(layers []DockerLayer) []DockerLayer {
-	var result []DockerLayer
+// LayerSizeHistogram counts the layers in each size range delimited by the bucket boundaries,
+// keyed by a readable label like "1.0 MB - 10.0 MB". A boundary belongs to the range above it.
+// Every range is present in the result, even when empty. With no boundaries all layers are
+// counted under "all".
+func LayerSizeHistogram(layers []DockerLayer, buckets []int64) map[string]int {
+	if len(buckets) == 0 {
+		return map[string]int{"all": len(layers)}
+	}
+
+	boundaries := append([]int64(nil), buckets...)
+	sort.Slice(boundaries, func(i, j int) bool {
+		return boundaries[i] < boundaries[j]
+	})
+	unique := boundaries[:1]
+	for _, boundary := range boundaries[1:] {
+		if boundary != unique[len(unique)-1] {
+			unique = append(unique, boundary)
+		}
+	}
+	boundaries = unique
+
+	labels := sizeBucketLabels(boundaries)
+	histogram := make(map[string]int, len(labels))
+	for _, label := range labels {
+		histogram[label] = 0
+	}
 	for _, layer := range layers {
-		if len(layer.Tags) > 0 {
-			result = append(result, layer)
+		i := sort.Search(len(boundaries), func(i int) bool {
+			return boundaries[i] > layer.Size
+		})
+		histogram[labels[i]]++
+	}
+	return histogram
+}
+
+// SizePercentiles returns the layer size at each of the percentiles ps (0 to 100), such as
+// 50, 90 and 99, computed like PercentileSize. Percentiles out of range are left out, and
+// the result is empty when there are no layers.
+func SizePercentiles(layers []DockerLayer, ps []float64) map[float64]int64 {
+	result := make(map[float64]int64)
+	if len(layers) == 0 {
+		return result
+	}
+
+	sizes := make([]int64, len(layers))
+	for i, layer := range layers {
+		sizes[i] = layer.Size
+	}
+	sort.Slice(sizes, func(i, j int) bool {
+		return sizes[i] < sizes[j]
+	})
+	for _, p := range ps {
+		if p < 0 || p > 100 || math.IsNaN(p) {
+			continue
 		}
+		result[p] = quantile(sizes, p/100)
 	}
 	return result
 }
 
-// LayersWithoutTags returns all layers that have no tags.
-func LayersWithoutTags(layers []DockerLayer) []DockerLayer {
+// TimeRange is a span of creation times. A zero Start means no lower bound and a zero End
+// means no upper bound. A range whose Start is after its End is empty.
+type TimeRange struct {
+	Start, End     time.Time
+	InclusiveStart bool // include layers created exactly at Start
+	InclusiveEnd   bool // include layers created exactly at End
+}
+
+// Contains reports whether t falls within the range.
+func (r TimeRange) Contains(t time.Time) bool {
+	if !r.Start.IsZero() && (t.Before(r.Start) || !r.InclusiveStart && t.Equal(r.Start)) {
+		return false
+	}
+	if !r.End.IsZero() && (t.After(r.End) || !r.InclusiveEnd && t.Equal(r.End)) {
+		return false
+	}
+	return true
+}
+
+// CreatedIn accepts layers created within r.
+func CreatedIn(r TimeRange) LayerFilter {
+	return func(layer DockerLayer) bool {
+		return r.Contains(layer.Created)
+	}
+}
+
+// LayersInRange returns all layers created within r. A reversed range returns no layers.
+func LayersInRange(layers []DockerLayer, r TimeRange) []DockerLayer {
+	return FilterLayers(layers, CreatedIn(r))
+}
+
+// LayersInDateRange returns all layers created in a specific date range. The range is inclusive:
+// layers created exactly at start or end are included. A zero start or end leaves that side of
+// the range open. If start is after end the range is empty and no layers are returned.
+func LayersInDateRange(layers []DockerLayer, start, end time.Time) []DockerLayer {
+	return LayersInRange(layers, TimeRange{Start: start, End: end, InclusiveStart: true, InclusiveEnd: true})
+}
+
+// StaleLayers returns all layers created more than olderThan ago.
+func StaleLayers(layers []DockerLayer, olderThan time.Duration) []DockerLayer {
+	return StaleLayersAt(layers, olderThan, time.Now())
+}
+
+// StaleLayersAt returns all layers that were older than olderThan at the reference time.
+// Layers with an unknown (zero) creation time are skipped.
+func StaleLayersAt(layers []DockerLayer, olderThan time.Duration, ref time.Time) []DockerLayer {
 	var result []DockerLayer
 	for _, layer := range layers {
-		if len(layer.Tags) == 0 {
+		if !layer.Created.IsZero() && layer.AgeAt(ref) > olderThan {
 			result = append(result, layer)
 		}
 	}
 	return result
 }
 
+// LayersWithTags returns all layers that have one or more tags.
+func LayersWithTags(layers []DockerLayer) []DockerLayer {
+	return FilterLayers(layers, TagCountAbove(0))
+}
+
+// LayersWithoutTags returns all layers that have no tags.
+func LayersWithoutTags(layers []DockerLayer) []DockerLayer {
+	return FilterLayers(layers, TagCountBelow(1))
+}
+
 // LayerWithTag returns all layers that contain a specific tag.
 func LayerWithTag(layers []DockerLayer, tag string) []DockerLayer {
-	var result []DockerLayer
-	for _, layer := range layers {
-		for _, t := range layer.Tags {
-			if t == tag {
-				result = append(result, layer)
-				break
-			}
-		}
-	}
-	return result
+	return FilterLayers(layers, HasTag(tag))
 }
 
 // LayerCountByAuthor returns a map with authors as keys and the number of layers they have created as values.
@@ -171,6 +343,16 @@ func LayerSizeByAuthor(layers []DockerLayer) map[string]int64 {
 	return result
 }
 
+// AverageSizeByAuthor returns a map with authors as keys and the average size of the layers they have created as values.
+func AverageSizeByAuthor(layers []DockerLayer) map[string]float64 {
+	counts := LayerCountByAuthor(layers)
+	result := make(map[string]float64, len(counts))
+	for author, size := range LayerSizeByAuthor(layers) {
+		result[author] = float64(size) / float64(counts[author])
+	}
+	return result
+}
+
 // TotalSize returns the total size of all layers.
 func TotalSize(layers []DockerLayer) int64 {
 	var total int64
@@ -205,15 +387,50 @@ func MedianSize(layers []DockerLayer) int64 {
 	}
 }
 
+// PercentileSize returns the p-th percentile (0 <= p <= 100) of the layer sizes, interpolating
+// linearly between the two nearest ranks.
+func PercentileSize(layers []DockerLayer, p float64) (int64, error) {
+	if p < 0 || p > 100 || math.IsNaN(p) {
+		return 0, fmt.Errorf("percentile out of range: %v", p)
+	}
+	if len(layers) == 0 {
+		return 0, fmt.Errorf("no layers")
+	}
+
+	sizes := make([]int64, len(layers))
+	for i, layer := range layers {
+		sizes[i] = layer.Size
+	}
+	sort.Slice(sizes, func(i, j int) bool {
+		return sizes[i] < sizes[j]
+	})
+	return quantile(sizes, p/100), nil
+}
+
 // FindLayers returns all layers that satisfy a given predicate.
 func FindLayers(layers []DockerLayer, predicate func(layer DockerLayer) bool) []DockerLayer {
-	var result []DockerLayer
-	for _, layer := range layers {
-		if predicate(layer) {
-			result = append(result, layer)
-		}
+	return FilterLayers(layers, predicate)
+}
+
+// LayersMatchingCommand returns all layers whose CreatedBy or Command matches the regular
+// expression pattern, such as `apt-get (update|install)`. It returns an error if the pattern
+// doesn't compile.
+func LayersMatchingCommand(layers []DockerLayer, pattern string) ([]DockerLayer, error) {
+	filter, err := ByCommandRegexp(pattern)
+	if err != nil {
+		return nil, err
 	}
-	return result
+	return FilterLayers(layers, filter), nil
+}
+
+// LayersLargerThan returns all layers whose size is strictly greater than threshold bytes.
+func LayersLargerThan(layers []DockerLayer, threshold int64) []DockerLayer {
+	return FilterLayers(layers, Not(MaxSize(threshold)))
+}
+
+// LayersSmallerThan returns all layers whose size is strictly less than threshold bytes.
+func LayersSmallerThan(layers []DockerLayer, threshold int64) []DockerLayer {
+	return FilterLayers(layers, Not(MinSize(threshold)))
 }
 
 // AuthorsWithLayerSizeAbove returns all authors who have created layers above a certain size.
@@ -248,16 +465,12 @@ func AuthorsWithLayerSizeBelow(layers []DockerLayer, size int64) []string {
 
 // LayerWithTagCountAbove returns all layers that have a tag count above a certain number.
 func LayerWithTagCountAbove(layers []DockerLayer, count int) []DockerLayer {
-	return FindLayers(layers, func(layer DockerLayer) bool {
-		return len(layer.Tags) > count
-	})
+	return FilterLayers(layers, TagCountAbove(count))
 }
 
 // LayerWithTagCountBelow returns all layers that have a tag count below a certain number.
 func LayerWithTagCountBelow(layers []DockerLayer, count int) []DockerLayer {
-	return FindLayers(layers, func(layer DockerLayer) bool {
-		return len(layer.Tags) < count
-	})
+	return FilterLayers(layers, TagCountBelow(count))
 }
 
 // LayerCountOverTime returns a map from time to the number of layers created by that time.
@@ -283,3 +496,35 @@ func TagFrequency(layers []DockerLayer) map[string]int {
 	}
 	return result
 }
+
+// DuplicateCommands returns the commands that created more than one layer in an image, mapped to
+// those layers. Commands are compared after normalizing CreatedBy, so the same Dockerfile line
+// recorded by the legacy builder and by buildkit counts as one command.
+func DuplicateCommands(image *DockerImage) map[string][]DockerLayer {
+	layersByCommand := make(map[string][]DockerLayer)
+	for _, layer := range image.Layers {
+		command := normalizeInstruction(layer.CreatedBy)
+		if command == "" {
+			continue
+		}
+		layersByCommand[command] = append(layersByCommand[command], layer)
+	}
+
+	result := make(map[string][]DockerLayer)
+	for command, layers := range layersByCommand {
+		if len(layers) > 1 {
+			result[command] = layers
+		}
+	}
+	return result
+}
+
+// WastedSizeByDuplicates estimates the bytes spent re-running commands, counting every layer
+// of a duplicated command except the first one.
+func WastedSizeByDuplicates(image *DockerImage) int64 {
+	var wasted int64
+	for _, layers := range DuplicateCommands(image) {
+		wasted += TotalSize(layers[1:])
+	}
+	return wasted
+}
@@ -0,0 +1,109 @@
+package analysis
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLayersInRange(t *testing.T) {
+	t0 := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	t1 := t0.Add(time.Hour)
+	t2 := t0.Add(2 * time.Hour)
+	layers := []DockerLayer{
+		{ID: "a", Created: t0},
+		{ID: "b", Created: t1},
+		{ID: "c", Created: t2},
+	}
+
+	for _, tc := range []struct {
+		name string
+		r    TimeRange
+		want string
+	}{
+		{"inclusive bounds", TimeRange{Start: t0, End: t2, InclusiveStart: true, InclusiveEnd: true}, "abc"},
+		{"exclusive bounds", TimeRange{Start: t0, End: t2}, "b"},
+		{"inclusive start only", TimeRange{Start: t0, End: t2, InclusiveStart: true}, "ab"},
+		{"inclusive end only", TimeRange{Start: t0, End: t2, InclusiveEnd: true}, "bc"},
+		{"single instant inclusive", TimeRange{Start: t1, End: t1, InclusiveStart: true, InclusiveEnd: true}, "b"},
+		{"single instant exclusive", TimeRange{Start: t1, End: t1}, ""},
+		{"zero range", TimeRange{}, "abc"},
+		{"zero start", TimeRange{End: t1, InclusiveEnd: true}, "ab"},
+		{"zero end", TimeRange{Start: t1}, "c"},
+		{"reversed", TimeRange{Start: t2, End: t0, InclusiveStart: true, InclusiveEnd: true}, ""},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if ids := layerIDs(LayersInRange(layers, tc.r)); ids != tc.want {
+				t.Errorf("got layers %q, want %q", ids, tc.want)
+			}
+		})
+	}
+}
+
+func TestLayersInDateRange(t *testing.T) {
+	t0 := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	t1 := t0.Add(time.Hour)
+	layers := []DockerLayer{{ID: "a", Created: t0}, {ID: "b", Created: t1}}
+
+	for _, tc := range []struct {
+		name       string
+		start, end time.Time
+		want       string
+	}{
+		{"bounds are inclusive", t0, t1, "ab"},
+		{"zero start", time.Time{}, t0, "a"},
+		{"zero end", t1, time.Time{}, "b"},
+		{"reversed", t1, t0, ""},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if ids := layerIDs(LayersInDateRange(layers, tc.start, tc.end)); ids != tc.want {
+				t.Errorf("got layers %q, want %q", ids, tc.want)
+			}
+		})
+	}
+}
+
+// layerIDs concatenates the IDs of layers.
+func layerIDs(layers []DockerLayer) string {
+	var ids string
+	for _, layer := range layers {
+		ids += layer.ID
+	}
+	return ids
+}
+
+func TestLargestNLayersMatchesLargestLayers(t *testing.T) {
+	image := &DockerImage{Layers: []DockerLayer{
+		{ID: "d", Size: 10},
+		{ID: "b", Size: 30},
+		{ID: "a", Size: 10},
+		{ID: "e", Size: 0},
+		{ID: "c", Size: 30},
+	}}
+
+	for _, tc := range []struct {
+		n    int
+		want string
+	}{
+		{-5, ""},
+		{-1, ""},
+		{0, ""},
+		{1, "b"},
+		{2, "bc"},
+		{3, "bca"}, // ties are ordered by ID
+		{5, "bcade"},
+		{10, "bcade"},
+	} {
+		method := image.LargestNLayers(tc.n)
+		function := LargestLayers(image.Layers, tc.n)
+		if got := layerIDs(method); got != tc.want {
+			t.Errorf("LargestNLayers(%d) = %q, want %q", tc.n, got, tc.want)
+		}
+		if got := layerIDs(function); got != tc.want {
+			t.Errorf("LargestLayers(layers, %d) = %q, want %q", tc.n, got, tc.want)
+		}
+	}
+
+	if got := layerIDs(image.Layers); got != "dbaec" {
+		t.Errorf("LargestNLayers reordered the image's layers: %q", got)
+	}
+}
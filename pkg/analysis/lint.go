@@ -0,0 +1,301 @@
+package analysis
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// Severity is how serious a lint finding is.
+type Severity int
+
+const (
+	SeverityInfo Severity = iota
+	SeverityWarning
+	SeverityError
+)
+
+// String returns the lowercase name of the severity.
+func (s Severity) String() string {
+	switch s {
+	case SeverityInfo:
+		return "info"
+	case SeverityWarning:
+		return "warning"
+	case SeverityError:
+		return "error"
+	default:
+		return fmt.Sprintf("severity(%d)", int(s))
+	}
+}
+
+// Finding is a problem reported by a lint rule.
+type Finding struct {
+	Rule     string
+	Severity Severity
+	LayerID  string // empty for findings about the image as a whole
+	Message  string
+}
+
+// String returns the finding as "severity [rule] layer: message".
+func (f Finding) String() string {
+	if f.LayerID == "" {
+		return fmt.Sprintf("%s [%s] %s", f.Severity, f.Rule, f.Message)
+	}
+	return fmt.Sprintf("%s [%s] %s: %s", f.Severity, f.Rule, shortID(f.LayerID), f.Message)
+}
+
+// Rule checks an image for a problem.
+type Rule interface {
+	Check(image *DockerImage) []Finding
+}
+
+// RuleFunc adapts an ordinary function to the Rule interface.
+type RuleFunc func(image *DockerImage) []Finding
+
+// Check calls f(image).
+func (f RuleFunc) Check(image *DockerImage) []Finding {
+	return f(image)
+}
+
+// RuleConfig holds the thresholds of the built-in rules.
+type RuleConfig struct {
+	MaxLayerSize int64 // in bytes
+	MaxLayers    int   // number of layers that add files
+}
+
+// DefaultRuleConfig returns the thresholds used when none are given: 100 MB per layer and 30 layers.
+func DefaultRuleConfig() RuleConfig {
+	return RuleConfig{
+		MaxLayerSize: 100e6,
+		MaxLayers:    30,
+	}
+}
+
+// DefaultRules returns the built-in rules configured with config.
+func DefaultRules(config RuleConfig) []Rule {
+	return []Rule{
+		LargeLayerRule(config.MaxLayerSize),
+		LayerCountRule(config.MaxLayers),
+		AptGetRule(),
+		AddInsteadOfCopyRule(),
+		LatestTagRule(),
+		SecretEnvRule(),
+	}
+}
+
+var (
+	registeredRulesMu sync.Mutex
+	registeredRules   []Rule
+)
+
+// RegisterRule adds a custom rule that LintImage runs along with the built-in rules
+// when it is called without explicit rules.
+func RegisterRule(rule Rule) {
+	registeredRulesMu.Lock()
+	defer registeredRulesMu.Unlock()
+	registeredRules = append(registeredRules, rule)
+}
+
+// RegisteredRules returns the custom rules added with RegisterRule.
+func RegisteredRules() []Rule {
+	registeredRulesMu.Lock()
+	defer registeredRulesMu.Unlock()
+	return append([]Rule(nil), registeredRules...)
+}
+
+// LintImage checks an image against rules and returns what they find, in rule order.
+// Without rules it uses DefaultRules(DefaultRuleConfig()) followed by the registered rules.
+func LintImage(image *DockerImage, rules ...Rule) []Finding {
+	if len(rules) == 0 {
+		rules = append(DefaultRules(DefaultRuleConfig()), RegisteredRules()...)
+	}
+
+	var findings []Finding
+	for _, rule := range rules {
+		findings = append(findings, rule.Check(image)...)
+	}
+	return findings
+}
+
+// LargeLayerRule reports layers larger than maxSize bytes.
+func LargeLayerRule(maxSize int64) Rule {
+	return RuleFunc(func(image *DockerImage) []Finding {
+		var findings []Finding
+		for _, layer := range image.Layers {
+			if layer.Size > maxSize {
+				findings = append(findings, Finding{
+					Rule:     "large-layer",
+					Severity: SeverityWarning,
+					LayerID:  layer.ID,
+					Message: fmt.Sprintf("layer is %s, larger than %s: %s",
+						FormatSize(layer.Size), FormatSize(maxSize), truncate(normalizeInstruction(layer.CreatedBy), 80)),
+				})
+			}
+		}
+		return findings
+	})
+}
+
+// LayerCountRule reports images with more than max layers. Only layers that add files count;
+// instructions such as ENV or CMD show up in the history with a size of zero.
+func LayerCountRule(max int) Rule {
+	return RuleFunc(func(image *DockerImage) []Finding {
+		count := 0
+		for _, layer := range image.Layers {
+			if layer.Size > 0 {
+				count++
+			}
+		}
+		if count <= max {
+			return nil
+		}
+		return []Finding{{
+			Rule:     "layer-count",
+			Severity: SeverityWarning,
+			Message:  fmt.Sprintf("image has %d layers, more than %d", count, max),
+		}}
+	})
+}
+
+// aptGetInstall matches apt-get and apt package installs.
+var aptGetInstall = regexp.MustCompile(`\bapt(-get)? +(\S+ +)*install\b`)
+
+// AptGetRule reports apt-get installs that pull in recommended packages or leave the package lists in the layer.
+func AptGetRule() Rule {
+	return RuleFunc(func(image *DockerImage) []Finding {
+		var findings []Finding
+		for _, layer := range image.Layers {
+			instruction := normalizeInstruction(layer.CreatedBy)
+			if !strings.HasPrefix(instruction, "RUN ") || !aptGetInstall.MatchString(instruction) {
+				continue
+			}
+			if !strings.Contains(instruction, "--no-install-recommends") {
+				findings = append(findings, Finding{
+					Rule:     "apt-get",
+					Severity: SeverityInfo,
+					LayerID:  layer.ID,
+					Message:  "apt-get install without --no-install-recommends",
+				})
+			}
+			if !strings.Contains(instruction, "/var/lib/apt/lists") {
+				findings = append(findings, Finding{
+					Rule:     "apt-get",
+					Severity: SeverityWarning,
+					LayerID:  layer.ID,
+					Message:  "apt-get install without rm -rf /var/lib/apt/lists/* in the same layer",
+				})
+			}
+		}
+		return findings
+	})
+}
+
+// addOnlySources holds the source suffixes that need ADD: remote archives aside, ADD
+// extracts local tarballs, which COPY can't do.
+var addOnlySources = []string{".tar", ".tar.gz", ".tgz", ".tar.bz2", ".tbz2", ".tar.xz", ".txz", ".tar.zst"}
+
+// needsAdd reports whether an ADD source relies on ADD rather than COPY.
+func needsAdd(source string) bool {
+	if strings.Contains(source, "://") || strings.HasPrefix(source, "git@") {
+		return true
+	}
+	for _, suffix := range addOnlySources {
+		if strings.HasSuffix(source, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// AddInsteadOfCopyRule reports ADD instructions that copy local files, which COPY does more predictably.
+// ADD of URLs and archives is allowed, and so is ADD to "/", which is how base images add their root filesystem.
+func AddInsteadOfCopyRule() Rule {
+	return RuleFunc(func(image *DockerImage) []Finding {
+		var findings []Finding
+		for _, layer := range image.Layers {
+			instruction := normalizeInstruction(layer.CreatedBy)
+			if !strings.HasPrefix(instruction, "ADD ") {
+				continue
+			}
+
+			var args []string
+			for _, arg := range strings.Fields(strings.TrimPrefix(instruction, "ADD ")) {
+				// Skip flags and the "in" docker writes between sources and destination.
+				if !strings.HasPrefix(arg, "--") && arg != "in" {
+					args = append(args, arg)
+				}
+			}
+			if len(args) < 2 || path.Clean(args[len(args)-1]) == "/" {
+				continue
+			}
+			allowed := false
+			for _, source := range args[:len(args)-1] {
+				if needsAdd(source) {
+					allowed = true
+				}
+			}
+			if !allowed {
+				findings = append(findings, Finding{
+					Rule:     "add-instead-of-copy",
+					Severity: SeverityInfo,
+					LayerID:  layer.ID,
+					Message:  "ADD used for local files; COPY suffices",
+				})
+			}
+		}
+		return findings
+	})
+}
+
+// LatestTagRule reports images referenced by the "latest" tag, explicitly or by omitting the tag.
+// Unnamed images and images named by digest are skipped.
+func LatestTagRule() Rule {
+	return RuleFunc(func(image *DockerImage) []Finding {
+		if image.Name == "" || strings.Contains(image.Name, "@") {
+			return nil
+		}
+		name := image.Name[strings.LastIndex(image.Name, "/")+1:]
+		_, tag, found := strings.Cut(name, ":")
+		if found && tag != "latest" {
+			return nil
+		}
+		return []Finding{{
+			Rule:     "latest-tag",
+			Severity: SeverityWarning,
+			Message:  fmt.Sprintf("image %s uses the latest tag; pin a version or digest", image.Name),
+		}}
+	})
+}
+
+// secretAssignment matches assignments to variables whose names look like they hold secrets,
+// as recorded for ENV and ARG instructions and for the build args buildkit prefixes RUN steps with.
+var secretAssignment = regexp.MustCompile(`(?i)(?:^|[\s|"'])([A-Z0-9_]*(?:SECRET|TOKEN|PASSWORD|PASSWD|API_?KEY|ACCESS_?KEY|PRIVATE_?KEY|CREDENTIALS?)[A-Z0-9_]*)=([^\s"']+)`)
+
+// SecretEnvRule reports layers whose CreatedBy sets a variable that looks like it holds a secret,
+// such as AWS_SECRET_ACCESS_KEY, GITHUB_TOKEN or DB_PASSWORD. Values are baked into the image
+// history for anyone who can pull it; the findings name the variables but not their values.
+func SecretEnvRule() Rule {
+	return RuleFunc(func(image *DockerImage) []Finding {
+		var findings []Finding
+		for _, layer := range image.Layers {
+			seen := make(map[string]bool)
+			for _, match := range secretAssignment.FindAllStringSubmatch(layer.CreatedBy, -1) {
+				name := match[1]
+				if seen[name] {
+					continue
+				}
+				seen[name] = true
+				findings = append(findings, Finding{
+					Rule:     "secret-env",
+					Severity: SeverityError,
+					LayerID:  layer.ID,
+					Message:  fmt.Sprintf("%s is set in the image history and may expose a secret", name),
+				})
+			}
+		}
+		return findings
+	})
+}
@@ -0,0 +1,60 @@
+package analysis
+
+import "testing"
+
+// latestTagFindings returns the findings of LatestTagRule for image.
+func latestTagFindings(image *DockerImage) []Finding {
+	return LintImage(image, LatestTagRule())
+}
+
+func TestLatestTagRule(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		want bool
+	}{
+		{"", false},
+		{"app", true},
+		{"app:latest", true},
+		{"registry.local:5000/team/app", true},
+		{"app:1.4", false},
+		{"registry.local:5000/team/app:1.4", false},
+		{"app@sha256:abc", false},
+	} {
+		if got := len(latestTagFindings(&DockerImage{Name: tc.name})) > 0; got != tc.want {
+			t.Errorf("LatestTagRule(%q) reported %t, want %t", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestLatestTagRuleOfflineImages(t *testing.T) {
+	layer := writeTar(t, tarEntry{name: "app", data: []byte("binary")})
+	for _, tc := range []struct {
+		name     string
+		repoTags string
+		want     bool
+	}{
+		{"saved by ID", `[]`, false},
+		{"saved by tag", `["app:1.4"]`, false},
+		{"saved as latest", `["app:latest"]`, true},
+	} {
+		t.Run("tar "+tc.name, func(t *testing.T) {
+			image, err := NewDockerImageFromTar(writeSaveArchive(t, singleLayerConfig, tc.repoTags, layer))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := len(latestTagFindings(image)) > 0; got != tc.want {
+				t.Errorf("LatestTagRule(%q) reported %t, want %t", image.Name, got, tc.want)
+			}
+		})
+	}
+
+	t.Run("oci without annotation", func(t *testing.T) {
+		image, err := NewDockerImageFromOCILayout(writeOCILayout(t, singleLayerConfig, nil, layer))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if findings := latestTagFindings(image); len(findings) > 0 {
+			t.Errorf("got latest-tag findings for an unnamed image: %v", findings)
+		}
+	})
+}
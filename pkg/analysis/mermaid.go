@@ -0,0 +1,148 @@
+package analysis
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// MermaidOptions controls the diagram produced by ExportMermaid.
+type MermaidOptions struct {
+	// Direction is the Mermaid graph direction: TD, TB, BT, LR or RL. Defaults to TD.
+	Direction string
+	// MaxNodes is the maximum number of layers drawn per image. Older layers beyond it
+	// are collapsed into a single node. Zero means no limit.
+	MaxNodes int
+}
+
+var mermaidEscaper = strings.NewReplacer(`"`, "#quot;", "<", "#lt;", ">", "#gt;")
+
+// mermaidLabel quotes a multi-line node label, escaping the characters Mermaid treats specially.
+func mermaidLabel(lines ...string) string {
+	escaped := make([]string, 0, len(lines))
+	for _, line := range lines {
+		if line != "" {
+			escaped = append(escaped, mermaidEscaper.Replace(line))
+		}
+	}
+	return `"` + strings.Join(escaped, "<br/>") + `"`
+}
+
+// shortID returns the first 12 characters of a layer digest without its algorithm prefix.
+func shortID(id string) string {
+	if i := strings.Index(id, ":"); i >= 0 {
+		id = id[i+1:]
+	}
+	if len(id) > 12 {
+		id = id[:12]
+	}
+	return id
+}
+
+// ExportMermaid writes the layer hierarchy of the given images as a Mermaid flowchart.
+// Layers shared between images are drawn once, layers unique to an image are grouped
+// in a subgraph headed by the image name, and edges point from parent to child layer.
+// Nodes are numbered in the order they are drawn, as digests make poor Mermaid IDs.
+func ExportMermaid(w io.Writer, images []*DockerImage, opts MermaidOptions) error {
+	direction := opts.Direction
+	if direction == "" {
+		direction = "TD"
+	}
+	switch direction {
+	case "TD", "TB", "BT", "LR", "RL":
+	default:
+		return fmt.Errorf("invalid mermaid direction: %s", direction)
+	}
+
+	// Count how many images use each layer so shared layers can be drawn outside the subgraphs.
+	owners := make(map[string]int)
+	for _, image := range images {
+		seen := make(map[string]struct{})
+		for _, layer := range image.Layers {
+			if layer.ID == "<missing>" {
+				continue
+			}
+			if _, ok := seen[layer.ID]; !ok {
+				seen[layer.ID] = struct{}{}
+				owners[layer.ID]++
+			}
+		}
+	}
+
+	var b strings.Builder
+	var edges []string
+	edgeSet := make(map[string]struct{})
+	nodeIDs := make(map[string]string)
+	nodes := 0
+	addEdge := func(from, to string) {
+		edge := from + " --> " + to
+		if _, ok := edgeSet[edge]; !ok {
+			edgeSet[edge] = struct{}{}
+			edges = append(edges, edge)
+		}
+	}
+
+	fmt.Fprintf(&b, "graph %s\n", direction)
+	for i, image := range images {
+		layers := image.Layers
+		var collapsed []DockerLayer
+		if opts.MaxNodes > 0 && len(layers) > opts.MaxNodes {
+			collapsed = layers[:len(layers)-opts.MaxNodes]
+			layers = layers[len(layers)-opts.MaxNodes:]
+		}
+
+		var shared, own []string
+		previous := ""
+		if len(collapsed) > 0 {
+			previous = fmt.Sprintf("collapsed_%d", i)
+			own = append(own, fmt.Sprintf("%s[%s]", previous,
+				mermaidLabel(fmt.Sprintf("%d more layers", len(collapsed)), HumanSize(TotalSize(collapsed)))))
+		}
+		for _, layer := range layers {
+			// Layers with a "<missing>" ID can't be matched across images, so each gets its own node.
+			id, ok := nodeIDs[layer.ID]
+			if !ok {
+				id = fmt.Sprintf("L%d", nodes)
+				nodes++
+				if layer.ID != "<missing>" {
+					nodeIDs[layer.ID] = id
+				}
+				keyword := instructionKeyword(layer.CreatedBy)
+				if keyword == "" {
+					keyword = layer.Command
+				}
+				node := fmt.Sprintf("%s[%s]", id, mermaidLabel(shortID(layer.ID), HumanSize(layer.Size), keyword))
+				if owners[layer.ID] > 1 {
+					shared = append(shared, node)
+				} else {
+					own = append(own, node)
+				}
+			}
+			if previous != "" {
+				addEdge(previous, id)
+			}
+			previous = id
+		}
+
+		imageID := fmt.Sprintf("image_%d", i)
+		for _, node := range shared {
+			fmt.Fprintf(&b, "    %s\n", node)
+		}
+		name := image.Name
+		if name == "" {
+			name = fmt.Sprintf("image %d", i)
+		}
+		fmt.Fprintf(&b, "    subgraph %s[%s]\n", imageID, mermaidLabel(name))
+		for _, node := range own {
+			fmt.Fprintf(&b, "        %s\n", node)
+		}
+		fmt.Fprintf(&b, "    end\n")
+		fmt.Fprintf(&b, "    style %s fill:#eef,stroke:#336,stroke-width:2px\n", imageID)
+	}
+	for _, edge := range edges {
+		fmt.Fprintf(&b, "    %s\n", edge)
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
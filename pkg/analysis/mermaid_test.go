@@ -0,0 +1,53 @@
+package analysis
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// mermaidFleet is two images sharing a base layer; one is unnamed and both have "<missing>" layers.
+var mermaidFleet = []*DockerImage{
+	{Name: `team/"app"`, Layers: []DockerLayer{
+		{ID: "sha256:base0123456789abcdef", Size: 100000000, CreatedBy: "/bin/sh -c #(nop) ADD file:abc in / "},
+		{ID: "<missing>", CreatedBy: "/bin/sh -c #(nop)  ENV LANG=C.UTF-8"},
+		{ID: "sha256:app00123456789abcdef", Size: 5000000, CreatedBy: "COPY . /app # buildkit"},
+	}},
+	{Layers: []DockerLayer{
+		{ID: "sha256:base0123456789abcdef", Size: 100000000, CreatedBy: "/bin/sh -c #(nop) ADD file:abc in / "},
+		{ID: "<missing>", CreatedBy: "/bin/sh -c #(nop)  ENV LANG=C.UTF-8"},
+		{ID: "sha256:worker123456789abcdef", Size: 2000000, CreatedBy: "RUN /bin/sh -c make <all> # buildkit"},
+	}},
+}
+
+func TestExportMermaid(t *testing.T) {
+	for _, tc := range []struct {
+		golden string
+		opts   MermaidOptions
+	}{
+		{"mermaid_fleet.mmd", MermaidOptions{}},
+		{"mermaid_collapsed.mmd", MermaidOptions{Direction: "LR", MaxNodes: 2}},
+	} {
+		t.Run(tc.golden, func(t *testing.T) {
+			want, err := os.ReadFile(filepath.Join("testdata", tc.golden))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var b strings.Builder
+			if err := ExportMermaid(&b, mermaidFleet, tc.opts); err != nil {
+				t.Fatal(err)
+			}
+			if b.String() != string(want) {
+				t.Errorf("got\n%s\nwant\n%s", b.String(), want)
+			}
+		})
+	}
+}
+
+func TestExportMermaidInvalidDirection(t *testing.T) {
+	var b strings.Builder
+	if err := ExportMermaid(&b, mermaidFleet, MermaidOptions{Direction: "UP"}); err == nil || b.Len() != 0 {
+		t.Errorf("got error %v and output %q, want an error and no output", err, b.String())
+	}
+}
@@ -0,0 +1,116 @@
+package analysis
+
+import (
+	"archive/tar"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+)
+
+// readSaveImage reads the config and the layer sizes of the image in a `docker save` tarball.
+// Layer sizes are the sizes of the uncompressed layer tarballs, like `docker history` reports.
+func readSaveImage(archive string, manifest saveManifest) (imageConfig, []ociDescriptor, error) {
+	f, err := os.Open(archive)
+	if err != nil {
+		return imageConfig{}, nil, err
+	}
+	defer f.Close()
+
+	wanted := make(map[string]bool, len(manifest.Layers))
+	for _, layer := range manifest.Layers {
+		wanted[cleanPath(layer)] = true
+	}
+	sizes := make(map[string]int64)
+	links := make(map[string]string)
+	var config []byte
+	err = walkTar(f, func(header *tar.Header, r io.Reader) error {
+		name := cleanPath(header.Name)
+		switch {
+		case name == cleanPath(manifest.Config):
+			data, err := io.ReadAll(r)
+			config = data
+			return err
+		case !wanted[name]:
+		case header.Typeflag == tar.TypeSymlink:
+			// Older versions of docker save store a layer shared by several images once and link the others to it.
+			links[name] = cleanPath(path.Join(path.Dir(name), header.Linkname))
+		default:
+			sizes[name] = header.Size
+		}
+		return nil
+	})
+	if err != nil {
+		return imageConfig{}, nil, err
+	}
+	if config == nil {
+		return imageConfig{}, nil, fmt.Errorf("config %s not found in %s", manifest.Config, archive)
+	}
+
+	var parsed imageConfig
+	if err := json.Unmarshal(config, &parsed); err != nil {
+		return imageConfig{}, nil, fmt.Errorf("failed to parse image config: %w", err)
+	}
+
+	descriptors := make([]ociDescriptor, len(manifest.Layers))
+	for i, layer := range manifest.Layers {
+		name := cleanPath(layer)
+		if target, ok := links[name]; ok {
+			name = target
+		}
+		size, ok := sizes[name]
+		if !ok {
+			return imageConfig{}, nil, fmt.Errorf("layer %s not found in %s", layer, archive)
+		}
+		descriptors[i] = ociDescriptor{Digest: layer, Size: size}
+		if i < len(parsed.RootFS.DiffIDs) {
+			descriptors[i].Digest = parsed.RootFS.DiffIDs[i]
+		}
+	}
+	return parsed, descriptors, nil
+}
+
+// NewDockerImageFromTar builds a DockerImage from a tarball written by `docker save`, without a
+// docker daemon. The tarball is streamed, never extracted. Layers come from the image config's
+// history: instructions that didn't change the filesystem (ENV, LABEL, ...) are zero-size layers
+// with the "<missing>" ID, and the others are identified by their diff ID and sized like
+// `docker history` does. Only tarballs holding a single image are supported. The image is named
+// after its first repo tag; an image saved by ID has none and is left unnamed.
+func NewDockerImageFromTar(path string) (*DockerImage, error) {
+	manifest, err := readSaveManifest(path)
+	if err != nil {
+		return nil, err
+	}
+	config, descriptors, err := readSaveImage(path, manifest)
+	if err != nil {
+		return nil, err
+	}
+
+	image := newDockerImageFromConfig(config, descriptors)
+	if len(manifest.RepoTags) > 0 {
+		image.Name = manifest.RepoTags[0]
+	}
+	return image, nil
+}
+
+// NewDockerImageFromOCILayout builds a DockerImage from an OCI image layout directory, as written
+// by `docker buildx build --output type=oci` or skopeo, without a docker daemon. Layers are mapped
+// like NewDockerImageFromTar does, but are identified by their blob digest and sized by their
+// blob, which is usually compressed. Only layouts holding a single image are supported. The image
+// is named after the reference in the io.containerd.image.name annotation of index.json, and left
+// unnamed without it.
+func NewDockerImageFromOCILayout(dir string) (*DockerImage, error) {
+	manifest, err := readOCIManifest(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read OCI layout: %w", err)
+	}
+	var config imageConfig
+	if err := readOCIBlob(dir, manifest.Config.Digest, &config); err != nil {
+		return nil, fmt.Errorf("failed to read image config: %w", err)
+	}
+
+	image := newDockerImageFromConfig(config, manifest.Layers)
+	image.Name = readOCIImageName(dir)
+	return image, nil
+}
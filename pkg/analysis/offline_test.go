@@ -0,0 +1,237 @@
+package analysis
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeSaveArchive writes a `docker save` tarball of a single image with the given config,
+// repo tags (a JSON array) and layer tarballs, and returns its path.
+func writeSaveArchive(t *testing.T, config []byte, repoTags string, layers ...[]byte) string {
+	t.Helper()
+	entries := []tarEntry{{name: "config.json", data: config}}
+	var names []string
+	for i, layer := range layers {
+		name := fmt.Sprintf("l%d/layer.tar", i)
+		names = append(names, name)
+		entries = append(entries, tarEntry{name: name, data: layer})
+	}
+	layerNames, _ := json.Marshal(names)
+	manifest := []byte(`[{"Config":"config.json","RepoTags":` + repoTags + `,"Layers":` + string(layerNames) + `}]`)
+	entries = append([]tarEntry{{name: "manifest.json", data: manifest}}, entries...)
+
+	archive := filepath.Join(t.TempDir(), "image.tar")
+	if err := os.WriteFile(archive, writeTar(t, entries...), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return archive
+}
+
+// writeOCILayout writes an OCI layout directory holding a single image with the given config and
+// layer blobs, whose index entry has annotations, and returns its path.
+func writeOCILayout(t *testing.T, config []byte, annotations map[string]string, layers ...[]byte) string {
+	t.Helper()
+	dir := t.TempDir()
+	writeBlob := func(data []byte) ociDescriptor {
+		digest := sha256Digest(data)
+		path, err := ociBlobPath(dir, digest)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return ociDescriptor{Digest: digest, Size: int64(len(data))}
+	}
+
+	manifest := ociManifest{Config: writeBlob(config)}
+	for _, layer := range layers {
+		manifest.Layers = append(manifest.Layers, writeBlob(layer))
+	}
+	data, _ := json.Marshal(manifest)
+	entry := writeBlob(data)
+	entry.Annotations = annotations
+	index, _ := json.Marshal(ociManifest{Manifests: []ociDescriptor{entry}})
+	if err := os.WriteFile(filepath.Join(dir, "index.json"), index, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+// singleLayerConfig is the config of an image with one COPY layer.
+var singleLayerConfig = []byte(`{"architecture":"amd64","os":"linux","history":[{"created_by":"COPY app /app"}],"rootfs":{"type":"layers","diff_ids":["sha256:d1"]}}`)
+
+func TestNewDockerImageFromTarName(t *testing.T) {
+	layer := writeTar(t, tarEntry{name: "app", data: []byte("binary")})
+	for _, tc := range []struct {
+		name     string
+		repoTags string
+		want     string
+	}{
+		{"saved by ID", `[]`, ""},
+		{"saved by tag", `["app:1.4"]`, "app:1.4"},
+		{"several tags", `["app:1.4","app:latest"]`, "app:1.4"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			image, err := NewDockerImageFromTar(writeSaveArchive(t, singleLayerConfig, tc.repoTags, layer))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if image.Name != tc.want {
+				t.Errorf("Name = %q, want %q", image.Name, tc.want)
+			}
+		})
+	}
+}
+
+func TestNewDockerImageFromOCILayoutName(t *testing.T) {
+	layer := writeTar(t, tarEntry{name: "app", data: []byte("binary")})
+	for _, tc := range []struct {
+		name        string
+		annotations map[string]string
+		want        string
+	}{
+		{"without annotation", nil, ""},
+		{"with other annotations", map[string]string{"org.opencontainers.image.ref.name": "1.4"}, ""},
+		{"with containerd name", map[string]string{containerdImageName: "docker.io/library/app:1.4"}, "docker.io/library/app:1.4"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			image, err := NewDockerImageFromOCILayout(writeOCILayout(t, singleLayerConfig, tc.annotations, layer))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if image.Name != tc.want {
+				t.Errorf("Name = %q, want %q", image.Name, tc.want)
+			}
+		})
+	}
+}
+
+func TestNewDockerImageFromConfig(t *testing.T) {
+	created := time.Date(2023, 6, 14, 9, 0, 0, 0, time.UTC)
+	history := []configHistory{
+		{Created: created, CreatedBy: "/bin/sh -c #(nop) ADD file:abc in / "},
+		{Created: created, CreatedBy: "/bin/sh -c #(nop)  ENV LANG=C.UTF-8", EmptyLayer: true},
+		{Created: created, CreatedBy: "RUN make install # buildkit", Author: "ops"},
+		{Created: created, CreatedBy: `CMD ["./server"]`, EmptyLayer: true},
+	}
+
+	for _, tc := range []struct {
+		name        string
+		descriptors []ociDescriptor
+		want        []DockerLayer
+	}{
+		{
+			"descriptors line up with history",
+			[]ociDescriptor{{Digest: "sha256:d1", Size: 100}, {Digest: "sha256:d2", Size: 50}},
+			[]DockerLayer{
+				{ID: "sha256:d1", Size: 100, Command: "ADD"},
+				{ID: "<missing>", Command: "ENV"},
+				{ID: "sha256:d2", Size: 50, Command: "RUN", Author: "ops"},
+				{ID: "<missing>", Command: "CMD"},
+			},
+		},
+		{
+			"extra descriptors are appended",
+			[]ociDescriptor{{Digest: "sha256:d1", Size: 100}, {Digest: "sha256:d2", Size: 50}, {Digest: "sha256:d3", Size: 7}},
+			[]DockerLayer{
+				{ID: "sha256:d1", Size: 100, Command: "ADD"},
+				{ID: "<missing>", Command: "ENV"},
+				{ID: "sha256:d2", Size: 50, Command: "RUN", Author: "ops"},
+				{ID: "<missing>", Command: "CMD"},
+				{ID: "sha256:d3", Size: 7},
+			},
+		},
+		{
+			"missing descriptors",
+			[]ociDescriptor{{Digest: "sha256:d1", Size: 100}},
+			[]DockerLayer{
+				{ID: "sha256:d1", Size: 100, Command: "ADD"},
+				{ID: "<missing>", Command: "ENV"},
+				{ID: "<missing>", Command: "RUN", Author: "ops"},
+				{ID: "<missing>", Command: "CMD"},
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			config := imageConfig{Created: created.Add(time.Hour), History: history}
+			image := newDockerImageFromConfig(config, tc.descriptors)
+			if len(image.Layers) != len(tc.want) {
+				t.Fatalf("got %d layers, want %d", len(image.Layers), len(tc.want))
+			}
+			var size int64
+			for i, want := range tc.want {
+				got := image.Layers[i]
+				if got.ID != want.ID || got.Size != want.Size || got.Command != want.Command || got.Author != want.Author {
+					t.Errorf("layer %d: got %s %s of %d bytes by %q, want %s %s of %d bytes by %q",
+						i, got.Command, got.ID, got.Size, got.Author, want.Command, want.ID, want.Size, want.Author)
+				}
+				if i < len(history) && (got.CreatedBy != history[i].CreatedBy || !got.Created.Equal(created)) {
+					t.Errorf("layer %d: got %q created %v, want the history entry", i, got.CreatedBy, got.Created)
+				}
+				if i >= len(history) && (got.CreatedBy != "" || !got.Created.Equal(config.Created)) {
+					t.Errorf("layer %d: got %q created %v, want no command created with the image", i, got.CreatedBy, got.Created)
+				}
+				if i == 0 && got.Parent != nil || i > 0 && got.Parent != &image.Layers[i-1] {
+					t.Errorf("layer %d: wrong parent", i)
+				}
+				size += want.Size
+			}
+			if image.Size != size {
+				t.Errorf("Size = %d, want %d", image.Size, size)
+			}
+		})
+	}
+}
+
+func TestNewDockerImageFromTarLayers(t *testing.T) {
+	config := []byte(`{"created":"2023-06-14T09:00:00Z","history":[` +
+		`{"created_by":"ADD rootfs.tar /"},` +
+		`{"created_by":"ENV LANG=C.UTF-8","empty_layer":true},` +
+		`{"created_by":"COPY app /app"}],` +
+		`"rootfs":{"type":"layers","diff_ids":["sha256:d1","sha256:d2"]}}`)
+	base := writeTar(t, tarEntry{name: "bin/sh", data: bytes.Repeat([]byte("x"), 1000)})
+	app := writeTar(t, tarEntry{name: "app", data: []byte("binary")})
+
+	image, err := NewDockerImageFromTar(writeSaveArchive(t, config, `["app:1.4"]`, base, app))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, want := range []DockerLayer{
+		{ID: "sha256:d1", Size: int64(len(base)), Command: "ADD"},
+		{ID: "<missing>", Command: "ENV"},
+		{ID: "sha256:d2", Size: int64(len(app)), Command: "COPY"},
+	} {
+		if got := image.Layers[i]; got.ID != want.ID || got.Size != want.Size || got.Command != want.Command {
+			t.Errorf("layer %d: got %s %s of %d bytes, want %s %s of %d bytes", i, got.Command, got.ID, got.Size, want.Command, want.ID, want.Size)
+		}
+	}
+	if image.Size != int64(len(base)+len(app)) {
+		t.Errorf("Size = %d, want %d", image.Size, len(base)+len(app))
+	}
+}
+
+func TestNewDockerImageFromTarMissingLayer(t *testing.T) {
+	manifest := []byte(`[{"Config":"config.json","RepoTags":["app:1.4"],"Layers":["l0/layer.tar","l1/layer.tar"]}]`)
+	archive := filepath.Join(t.TempDir(), "image.tar")
+	data := writeTar(t,
+		tarEntry{name: "manifest.json", data: manifest},
+		tarEntry{name: "config.json", data: singleLayerConfig},
+		tarEntry{name: "l0/layer.tar", data: writeTar(t, tarEntry{name: "app", data: []byte("binary")})},
+	)
+	if err := os.WriteFile(archive, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewDockerImageFromTar(archive); err == nil || !strings.Contains(err.Error(), "layer l1/layer.tar not found") {
+		t.Errorf("got error %v, want one naming the missing layer", err)
+	}
+}
@@ -0,0 +1,108 @@
+package analysis
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// PrewarmLayer is a layer in a pre-warm plan.
+type PrewarmLayer struct {
+	ID     string   `json:"id"`
+	Size   int64    `json:"size"`
+	Images []string `json:"images"`
+}
+
+// Plan is an ordered pull plan for the images scheduled to a node.
+type Plan struct {
+	// Images lists the image refs in the order they should be pulled.
+	Images []string `json:"images"`
+	// Layers lists the distinct layers, most shared and largest first.
+	Layers []PrewarmLayer `json:"layers"`
+	// TotalBytes is the number of bytes pulled when every distinct layer is fetched once.
+	TotalBytes int64 `json:"totalBytes"`
+	// NaiveBytes is the number of bytes pulled when every image is fetched on its own.
+	NaiveBytes int64 `json:"naiveBytes"`
+	// SavedBytes is NaiveBytes minus TotalBytes.
+	SavedBytes int64 `json:"savedBytes"`
+}
+
+// PrewarmPlan computes the order in which to pull images onto a node so that the layers
+// they share are fetched early and only once.
+func PrewarmPlan(images []*DockerImage) (Plan, error) {
+	if len(images) == 0 {
+		return Plan{}, errors.New("no images to plan")
+	}
+	for i, image := range images {
+		if image == nil {
+			return Plan{}, fmt.Errorf("image %d is nil", i)
+		}
+	}
+
+	var plan Plan
+	shared := collectSharedLayers(images)
+	sort.SliceStable(shared, func(i, j int) bool {
+		if len(shared[i].holders) != len(shared[j].holders) {
+			return len(shared[i].holders) > len(shared[j].holders)
+		}
+		return shared[i].layer.Size > shared[j].layer.Size
+	})
+
+	// An image's sharing score is the number of bytes other images will get from the cache once it is pulled.
+	scores := make(map[*DockerImage]int64, len(images))
+	for _, s := range shared {
+		layer := PrewarmLayer{ID: s.layer.ID, Size: s.layer.Size}
+		for _, image := range s.holders {
+			layer.Images = append(layer.Images, image.Name)
+			scores[image] += s.layer.Size * int64(len(s.holders)-1)
+		}
+		plan.Layers = append(plan.Layers, layer)
+		plan.TotalBytes += s.layer.Size
+	}
+
+	ordered := append([]*DockerImage(nil), images...)
+	sort.SliceStable(ordered, func(i, j int) bool {
+		if scores[ordered[i]] != scores[ordered[j]] {
+			return scores[ordered[i]] > scores[ordered[j]]
+		}
+		return TotalSize(ordered[i].Layers) > TotalSize(ordered[j].Layers)
+	})
+	for _, image := range ordered {
+		plan.Images = append(plan.Images, image.Name)
+		plan.NaiveBytes += TotalSize(image.Layers)
+	}
+	plan.SavedBytes = plan.NaiveBytes - plan.TotalBytes
+	return plan, nil
+}
+
+// WriteJSON writes the plan as JSON.
+func (plan Plan) WriteJSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(plan)
+}
+
+// WriteMarkdown writes the plan as a markdown document.
+func (plan Plan) WriteMarkdown(w io.Writer) error {
+	_, err := fmt.Fprintf(w, "# Pre-warm plan\n\nPull %s instead of %s (%s saved).\n\n## Images\n\n",
+		HumanSize(plan.TotalBytes), HumanSize(plan.NaiveBytes), HumanSize(plan.SavedBytes))
+	if err != nil {
+		return err
+	}
+	for i, image := range plan.Images {
+		if _, err := fmt.Fprintf(w, "%d. %s\n", i+1, image); err != nil {
+			return err
+		}
+	}
+	if _, err := fmt.Fprintf(w, "\n## Layers\n\n| Layer | Size | Images |\n| --- | ---: | ---: |\n"); err != nil {
+		return err
+	}
+	for _, layer := range plan.Layers {
+		if _, err := fmt.Fprintf(w, "| %s | %s | %d |\n", shortID(layer.ID), HumanSize(layer.Size), len(layer.Images)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
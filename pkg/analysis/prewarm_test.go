@@ -0,0 +1,124 @@
+package analysis
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// prewarmLayers returns the layers of a plan as "id:size:image,image".
+func prewarmLayers(layers []PrewarmLayer) []string {
+	var result []string
+	for _, layer := range layers {
+		result = append(result, fmt.Sprintf("%s:%d:%s", layer.ID, layer.Size, strings.Join(layer.Images, ",")))
+	}
+	return result
+}
+
+func TestPrewarmPlan(t *testing.T) {
+	base := DockerLayer{ID: "sha256:base", Size: 100}
+	deps := DockerLayer{ID: "sha256:deps", Size: 50}
+	missing := DockerLayer{ID: "<missing>", Size: 30}
+
+	for _, tc := range []struct {
+		name         string
+		images       []*DockerImage
+		wantImages   []string
+		wantLayers   []string
+		total, naive int64
+	}{
+		{
+			"most shared first",
+			[]*DockerImage{
+				{Name: "a", Layers: []DockerLayer{base, {ID: "sha256:a", Size: 10}}},
+				{Name: "b", Layers: []DockerLayer{base, deps, {ID: "sha256:b", Size: 20}}},
+				{Name: "c", Layers: []DockerLayer{base, deps, {ID: "sha256:c", Size: 5}}},
+				{Name: "d", Layers: []DockerLayer{{ID: "sha256:d", Size: 500}}},
+			},
+			// b and c share the most bytes; b goes first as it is larger.
+			[]string{"b", "c", "a", "d"},
+			[]string{"sha256:base:100:a,b,c", "sha256:deps:50:b,c", "sha256:d:500:d", "sha256:b:20:b", "sha256:a:10:a", "sha256:c:5:c"},
+			685, 935,
+		},
+		{
+			"layers repeated in an image",
+			[]*DockerImage{
+				{Name: "a", Layers: []DockerLayer{base, deps, deps}},
+				{Name: "b", Layers: []DockerLayer{base}},
+			},
+			[]string{"a", "b"},
+			[]string{"sha256:base:100:a,b", "sha256:deps:50:a"},
+			150, 300,
+		},
+		{
+			"missing layers aren't shared",
+			[]*DockerImage{
+				{Name: "a", Layers: []DockerLayer{base, missing}},
+				{Name: "b", Layers: []DockerLayer{base, missing}},
+			},
+			[]string{"a", "b"},
+			[]string{"sha256:base:100:a,b", "<missing>:30:a", "<missing>:30:b"},
+			160, 260,
+		},
+		{
+			"single image",
+			[]*DockerImage{{Name: "a", Layers: []DockerLayer{base, deps}}},
+			[]string{"a"},
+			[]string{"sha256:base:100:a", "sha256:deps:50:a"},
+			150, 150,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			plan, err := PrewarmPlan(tc.images)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if fmt.Sprint(plan.Images) != fmt.Sprint(tc.wantImages) {
+				t.Errorf("Images = %q, want %q", plan.Images, tc.wantImages)
+			}
+			if got := prewarmLayers(plan.Layers); fmt.Sprint(got) != fmt.Sprint(tc.wantLayers) {
+				t.Errorf("Layers = %q, want %q", got, tc.wantLayers)
+			}
+			if plan.TotalBytes != tc.total || plan.NaiveBytes != tc.naive || plan.SavedBytes != tc.naive-tc.total {
+				t.Errorf("got total %d, naive %d, saved %d; want %d, %d, %d",
+					plan.TotalBytes, plan.NaiveBytes, plan.SavedBytes, tc.total, tc.naive, tc.naive-tc.total)
+			}
+		})
+	}
+}
+
+func TestPrewarmPlanErrors(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		images []*DockerImage
+		want   string
+	}{
+		{"no images", nil, "no images to plan"},
+		{"nil image", []*DockerImage{{Name: "a"}, nil}, "image 1 is nil"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := PrewarmPlan(tc.images); err == nil || err.Error() != tc.want {
+				t.Errorf("got error %v, want %q", err, tc.want)
+			}
+		})
+	}
+}
+
+func TestPlanWriteMarkdown(t *testing.T) {
+	plan := Plan{
+		Images:     []string{"b", "a"},
+		Layers:     []PrewarmLayer{{ID: "sha256:0123456789abcdef", Size: 2048, Images: []string{"a", "b"}}},
+		TotalBytes: 2048,
+		NaiveBytes: 4096,
+		SavedBytes: 2048,
+	}
+	var b strings.Builder
+	if err := plan.WriteMarkdown(&b); err != nil {
+		t.Fatal(err)
+	}
+	want := "# Pre-warm plan\n\nPull 2.0 KB instead of 4.0 KB (2.0 KB saved).\n\n## Images\n\n1. b\n2. a\n\n" +
+		"## Layers\n\n| Layer | Size | Images |\n| --- | ---: | ---: |\n| 0123456789ab | 2.0 KB | 2 |\n"
+	if b.String() != want {
+		t.Errorf("got\n%s\nwant\n%s", b.String(), want)
+	}
+}
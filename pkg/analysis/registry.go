@@ -0,0 +1,476 @@
+package analysis
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const (
+	dockerHubRegistry = "registry-1.docker.io"
+	// maxRegistryResponse caps how much of a manifest or config blob is read.
+	maxRegistryResponse = 32 << 20
+)
+
+// manifestMediaTypes are the manifest formats requested from registries.
+var manifestMediaTypes = []string{
+	"application/vnd.docker.distribution.manifest.v2+json",
+	"application/vnd.oci.image.manifest.v1+json",
+	"application/vnd.docker.distribution.manifest.list.v2+json",
+	"application/vnd.oci.image.index.v1+json",
+}
+
+// RegistryOptions configures how images are fetched from a registry.
+type RegistryOptions struct {
+	// Username and Password are used for basic auth, and to request bearer tokens from
+	// registries that use token auth. Leave them empty for anonymous access.
+	Username string
+	Password string
+	// Token is a bearer token sent as is, for registries where one was obtained elsewhere.
+	Token string
+	// Platform selects the image of a multi-arch manifest list, as "os/arch" or
+	// "os/arch/variant", e.g. "linux/arm64". Required when the reference is a manifest list.
+	Platform string
+	// Insecure talks to the registry over plain HTTP.
+	Insecure bool
+	// HTTPClient is used for requests. Defaults to http.DefaultClient.
+	HTTPClient *http.Client
+}
+
+// imageReference is a parsed image reference such as "ghcr.io/org/app:1.0".
+type imageReference struct {
+	Registry   string // host used for API requests
+	Repository string
+	Reference  string // tag or digest
+}
+
+// parseReference splits an image reference into registry, repository and tag or digest.
+// References without a registry point to Docker Hub, and references without a tag use "latest".
+func parseReference(ref string) (imageReference, error) {
+	name, reference := ref, ""
+	if i := strings.Index(name, "@"); i >= 0 {
+		name, reference = name[:i], name[i+1:]
+	}
+	// A tag next to a digest is ignored, since the digest already identifies the image.
+	if i := strings.LastIndex(name, ":"); i > strings.LastIndex(name, "/") {
+		if reference == "" {
+			reference = name[i+1:]
+		}
+		name = name[:i]
+	}
+	if reference == "" {
+		reference = "latest"
+	}
+
+	registry := dockerHubRegistry
+	if first, rest, ok := strings.Cut(name, "/"); ok && (strings.ContainsAny(first, ".:") || first == "localhost") {
+		registry, name = first, rest
+	}
+	if registry == "docker.io" || registry == "index.docker.io" {
+		registry = dockerHubRegistry
+	}
+	if name == "" {
+		return imageReference{}, fmt.Errorf("invalid image reference: %q", ref)
+	}
+	if registry == dockerHubRegistry && !strings.Contains(name, "/") {
+		name = "library/" + name
+	}
+	return imageReference{Registry: registry, Repository: name, Reference: reference}, nil
+}
+
+// registryClient talks to one repository of a registry over the OCI distribution API.
+type registryClient struct {
+	opts       RegistryOptions
+	httpClient *http.Client
+	baseURL    string
+	repository string
+	token      string // bearer token obtained for the repository
+}
+
+// newRegistryClient creates a client for the repository of ref.
+func newRegistryClient(ref imageReference, opts RegistryOptions) *registryClient {
+	scheme := "https"
+	if opts.Insecure {
+		scheme = "http"
+	}
+	httpClient := opts.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	return &registryClient{
+		opts:       opts,
+		httpClient: httpClient,
+		baseURL:    scheme + "://" + ref.Registry + "/v2/" + ref.Repository,
+		repository: ref.Repository,
+		token:      opts.Token,
+	}
+}
+
+// authChallenge parses a WWW-Authenticate header into its scheme and parameters.
+func authChallenge(header string) (string, map[string]string) {
+	scheme, rest, _ := strings.Cut(strings.TrimSpace(header), " ")
+	params := make(map[string]string)
+	for rest != "" {
+		var key, value string
+		key, rest, _ = strings.Cut(strings.TrimLeft(rest, ", "), "=")
+		if strings.HasPrefix(rest, `"`) {
+			value, rest, _ = strings.Cut(rest[1:], `"`)
+		} else {
+			value, rest, _ = strings.Cut(rest, ",")
+		}
+		params[strings.ToLower(strings.TrimSpace(key))] = value
+	}
+	return strings.ToLower(scheme), params
+}
+
+// fetchToken requests a bearer token from the realm of a token auth challenge.
+func (c *registryClient) fetchToken(ctx context.Context, params map[string]string) (string, error) {
+	realm, err := url.Parse(params["realm"])
+	if err != nil || realm.Host == "" {
+		return "", fmt.Errorf("invalid token realm %q", params["realm"])
+	}
+	query := realm.Query()
+	if service := params["service"]; service != "" {
+		query.Set("service", service)
+	}
+	scope := params["scope"]
+	if scope == "" {
+		scope = "repository:" + c.repository + ":pull"
+	}
+	query.Set("scope", scope)
+	realm.RawQuery = query.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, realm.String(), nil)
+	if err != nil {
+		return "", err
+	}
+	if c.opts.Username != "" {
+		req.SetBasicAuth(c.opts.Username, c.opts.Password)
+	}
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("token request returned %s", resp.Status)
+	}
+
+	var token struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxRegistryResponse)).Decode(&token); err != nil {
+		return "", fmt.Errorf("failed to parse token response: %w", err)
+	}
+	if token.Token != "" {
+		return token.Token, nil
+	}
+	return token.AccessToken, nil
+}
+
+// get sends a GET request for path under the repository, authenticating when the registry asks to.
+// A 404 response returns an error wrapping ErrImageNotFound.
+func (c *registryClient) get(ctx context.Context, path string, accept []string) (*http.Response, error) {
+	send := func() (*http.Response, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.baseURL+path, nil)
+		if err != nil {
+			return nil, err
+		}
+		if len(accept) > 0 {
+			req.Header.Set("Accept", strings.Join(accept, ", "))
+		}
+		switch {
+		case c.token != "":
+			req.Header.Set("Authorization", "Bearer "+c.token)
+		case c.opts.Username != "":
+			req.SetBasicAuth(c.opts.Username, c.opts.Password)
+		}
+		return c.httpClient.Do(req)
+	}
+
+	resp, err := send()
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusUnauthorized {
+		scheme, params := authChallenge(resp.Header.Get("WWW-Authenticate"))
+		resp.Body.Close()
+		if scheme != "bearer" {
+			return nil, fmt.Errorf("registry returned %s", resp.Status)
+		}
+		if c.token, err = c.fetchToken(ctx, params); err != nil {
+			return nil, fmt.Errorf("failed to authenticate: %w", err)
+		}
+		if resp, err = send(); err != nil {
+			return nil, err
+		}
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		if resp.StatusCode == http.StatusNotFound {
+			return nil, fmt.Errorf("%w: %s", ErrImageNotFound, strings.TrimPrefix(path, "/"))
+		}
+		return nil, fmt.Errorf("registry returned %s for %s", resp.Status, strings.TrimPrefix(path, "/"))
+	}
+	return resp, nil
+}
+
+// getVerified fetches path and returns the response body. When digest is set the body is
+// checked against it.
+func (c *registryClient) getVerified(ctx context.Context, path string, accept []string, digest string) ([]byte, error) {
+	resp, err := c.get(ctx, path, accept)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxRegistryResponse))
+	if err != nil {
+		return nil, err
+	}
+	if strings.HasPrefix(digest, "sha256:") && sha256Digest(data) != digest {
+		return nil, fmt.Errorf("digest mismatch for %s", digest)
+	}
+	return data, nil
+}
+
+// sha256Digest returns the sha256 digest of data, e.g. "sha256:9f86d0...".
+func sha256Digest(data []byte) string {
+	sum := sha256.Sum256(data)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// manifest fetches the manifest or manifest list for a tag or digest, along with its digest.
+func (c *registryClient) manifest(ctx context.Context, reference string) (ociManifest, string, error) {
+	expected := ""
+	if strings.Contains(reference, ":") {
+		expected = reference
+	}
+	data, err := c.getVerified(ctx, "/manifests/"+reference, manifestMediaTypes, expected)
+	if err != nil {
+		return ociManifest{}, "", fmt.Errorf("failed to get manifest: %w", err)
+	}
+	var manifest ociManifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return ociManifest{}, "", fmt.Errorf("failed to parse manifest: %w", err)
+	}
+	return manifest, sha256Digest(data), nil
+}
+
+// config fetches the image config blob of a manifest.
+func (c *registryClient) config(ctx context.Context, manifest ociManifest) (imageConfig, error) {
+	data, err := c.getVerified(ctx, "/blobs/"+manifest.Config.Digest, nil, manifest.Config.Digest)
+	if err != nil {
+		return imageConfig{}, fmt.Errorf("failed to get image config: %w", err)
+	}
+	var config imageConfig
+	if err := json.Unmarshal(data, &config); err != nil {
+		return imageConfig{}, fmt.Errorf("failed to parse image config: %w", err)
+	}
+	return config, nil
+}
+
+// parsePlatform parses a platform written as "os/arch" or "os/arch/variant".
+func parsePlatform(s string) (ociPlatform, error) {
+	parts := strings.Split(s, "/")
+	if len(parts) < 2 || len(parts) > 3 || parts[0] == "" || parts[1] == "" {
+		return ociPlatform{}, fmt.Errorf("invalid platform %q: expected os/arch or os/arch/variant", s)
+	}
+	platform := ociPlatform{OS: parts[0], Architecture: parts[1]}
+	if len(parts) == 3 {
+		platform.Variant = parts[2]
+	}
+	return platform, nil
+}
+
+// matches reports whether p satisfies want. A want without a variant matches any variant.
+func (p ociPlatform) matches(want ociPlatform) bool {
+	return p.OS == want.OS && p.Architecture == want.Architecture && (want.Variant == "" || p.Variant == want.Variant)
+}
+
+// imagePlatforms returns the image entries of a manifest list, leaving out attestations
+// and other entries that aren't runnable images.
+func imagePlatforms(list ociManifest) []ociDescriptor {
+	var images []ociDescriptor
+	for _, descriptor := range list.Manifests {
+		if descriptor.Platform != nil && descriptor.Platform.OS != "unknown" {
+			images = append(images, descriptor)
+		}
+	}
+	return images
+}
+
+// platformNames returns the platforms of manifest list entries as strings.
+func platformNames(descriptors []ociDescriptor) string {
+	names := make([]string, len(descriptors))
+	for i, descriptor := range descriptors {
+		names[i] = descriptor.Platform.String()
+	}
+	return strings.Join(names, ", ")
+}
+
+// resolve fetches the image manifest and config for ref, picking the entry for platform
+// (empty for none) when ref is a manifest list.
+func (c *registryClient) resolve(ctx context.Context, ref, reference, platform string) (ociManifest, imageConfig, error) {
+	manifest, _, err := c.manifest(ctx, reference)
+	if err != nil {
+		return ociManifest{}, imageConfig{}, err
+	}
+
+	var want ociPlatform
+	if platform != "" {
+		if want, err = parsePlatform(platform); err != nil {
+			return ociManifest{}, imageConfig{}, err
+		}
+	}
+	if len(manifest.Manifests) > 0 {
+		images := imagePlatforms(manifest)
+		if platform == "" {
+			return ociManifest{}, imageConfig{}, fmt.Errorf("%s is a manifest list; specify a platform (available: %s)", ref, platformNames(images))
+		}
+		digest := ""
+		for _, descriptor := range images {
+			if descriptor.Platform.matches(want) {
+				digest = descriptor.Digest
+				break
+			}
+		}
+		if digest == "" {
+			return ociManifest{}, imageConfig{}, fmt.Errorf("%s has no image for %s (available: %s)", ref, platform, platformNames(images))
+		}
+		if manifest, _, err = c.manifest(ctx, digest); err != nil {
+			return ociManifest{}, imageConfig{}, err
+		}
+	}
+	if manifest.Config.Digest == "" {
+		return ociManifest{}, imageConfig{}, fmt.Errorf("unsupported manifest for %s: %s", ref, manifest.MediaType)
+	}
+
+	config, err := c.config(ctx, manifest)
+	if err != nil {
+		return ociManifest{}, imageConfig{}, err
+	}
+	actual := ociPlatform{OS: config.OS, Architecture: config.Architecture, Variant: config.Variant}
+	if platform != "" && !actual.matches(want) {
+		return ociManifest{}, imageConfig{}, fmt.Errorf("%s is a single %s image, not %s", ref, actual, platform)
+	}
+	return manifest, config, nil
+}
+
+// NewDockerImageFromRegistry builds a DockerImage from a registry without pulling the image,
+// by fetching its manifest and config blob over the OCI distribution API. Docker v2 schema 2
+// and OCI manifests are supported. Layer sizes are the compressed sizes from the manifest and
+// layer IDs their digests; history entries that didn't create a layer are zero-size layers
+// with the "<missing>" ID. It returns an error wrapping ErrImageNotFound if the registry
+// doesn't have the image. If ref is a manifest list, opts.Platform selects the image to load.
+func NewDockerImageFromRegistry(ctx context.Context, ref string, opts RegistryOptions) (*DockerImage, error) {
+	parsed, err := parseReference(ref)
+	if err != nil {
+		return nil, err
+	}
+	client := newRegistryClient(parsed, opts)
+	manifest, config, err := client.resolve(ctx, ref, parsed.Reference, opts.Platform)
+	if err != nil {
+		return nil, err
+	}
+
+	image := newDockerImageFromConfig(config, manifest.Layers)
+	image.Name = ref
+	return image, nil
+}
+
+// PlatformImage is the image for one platform of a multi-arch image.
+type PlatformImage struct {
+	OS           string
+	Architecture string
+	Variant      string
+	Digest       string // digest of the platform's manifest
+	Size         int64  // compressed size of the config and layers, in bytes
+}
+
+// Platform returns the platform as "os/arch" or "os/arch/variant", the form accepted by RegistryOptions.Platform.
+func (p PlatformImage) Platform() string {
+	return ociPlatform{OS: p.OS, Architecture: p.Architecture, Variant: p.Variant}.String()
+}
+
+// manifestSize returns the compressed size of an image manifest's config and layers.
+func manifestSize(manifest ociManifest) int64 {
+	size := manifest.Config.Size
+	for _, layer := range manifest.Layers {
+		size += layer.Size
+	}
+	return size
+}
+
+// ListPlatforms returns the images of a multi-arch image, one per platform, in manifest list order.
+// Each platform's manifest is fetched to compute its size. For an image that isn't a manifest
+// list, the single image is returned with the platform from its config.
+func ListPlatforms(ctx context.Context, ref string, opts RegistryOptions) ([]PlatformImage, error) {
+	parsed, err := parseReference(ref)
+	if err != nil {
+		return nil, err
+	}
+	client := newRegistryClient(parsed, opts)
+	manifest, digest, err := client.manifest(ctx, parsed.Reference)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(manifest.Manifests) == 0 {
+		if manifest.Config.Digest == "" {
+			return nil, fmt.Errorf("unsupported manifest for %s: %s", ref, manifest.MediaType)
+		}
+		config, err := client.config(ctx, manifest)
+		if err != nil {
+			return nil, err
+		}
+		return []PlatformImage{{
+			OS:           config.OS,
+			Architecture: config.Architecture,
+			Variant:      config.Variant,
+			Digest:       digest,
+			Size:         manifestSize(manifest),
+		}}, nil
+	}
+
+	var platforms []PlatformImage
+	for _, descriptor := range imagePlatforms(manifest) {
+		image, _, err := client.manifest(ctx, descriptor.Digest)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", descriptor.Platform, err)
+		}
+		platforms = append(platforms, PlatformImage{
+			OS:           descriptor.Platform.OS,
+			Architecture: descriptor.Platform.Architecture,
+			Variant:      descriptor.Platform.Variant,
+			Digest:       descriptor.Digest,
+			Size:         manifestSize(image),
+		})
+	}
+	return platforms, nil
+}
+
+// ComparePlatforms loads two platform variants of a multi-arch image, such as "linux/amd64"
+// and "linux/arm64", and diffs them. Layers built by the same instruction show up in the
+// diff's LayerDeltas, which points out the steps whose size differs between platforms.
+func ComparePlatforms(ctx context.Context, ref, p1, p2 string, opts RegistryOptions) (ImageDiff, error) {
+	opts.Platform = p1
+	a, err := NewDockerImageFromRegistry(ctx, ref, opts)
+	if err != nil {
+		return ImageDiff{}, err
+	}
+	opts.Platform = p2
+	b, err := NewDockerImageFromRegistry(ctx, ref, opts)
+	if err != nil {
+		return ImageDiff{}, err
+	}
+	return DiffImages(a, b), nil
+}
@@ -0,0 +1,437 @@
+package analysis
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+const (
+	dockerManifestType = "application/vnd.docker.distribution.manifest.v2+json"
+	ociManifestType    = "application/vnd.oci.image.manifest.v1+json"
+	ociIndexType       = "application/vnd.oci.image.index.v1+json"
+)
+
+// testRegistry is a registry serving the manifests and blobs added to it over the OCI
+// distribution API. With a token it requires bearer token auth, handing out the token at
+// /token to clients presenting username and password; with only a username it requires basic auth.
+type testRegistry struct {
+	*httptest.Server
+	content       map[string][]byte // response body, by URL path
+	token         string
+	username      string
+	password      string
+	tokenRequests int32
+}
+
+func newTestRegistry(t *testing.T) *testRegistry {
+	r := &testRegistry{content: make(map[string][]byte)}
+	r.Server = httptest.NewServer(http.HandlerFunc(r.serve))
+	t.Cleanup(r.Close)
+	return r
+}
+
+func (r *testRegistry) serve(w http.ResponseWriter, req *http.Request) {
+	if req.URL.Path == "/token" {
+		atomic.AddInt32(&r.tokenRequests, 1)
+		if user, password, _ := req.BasicAuth(); user != r.username || password != r.password {
+			http.Error(w, "bad credentials", http.StatusUnauthorized)
+			return
+		}
+		if req.URL.Query().Get("service") != "test-registry" {
+			http.Error(w, "unknown service", http.StatusBadRequest)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"token": r.token})
+		return
+	}
+
+	authorized := true
+	switch {
+	case r.token != "":
+		authorized = req.Header.Get("Authorization") == "Bearer "+r.token
+		w.Header().Set("WWW-Authenticate", `Bearer realm="`+r.URL+`/token",service="test-registry"`)
+	case r.username != "":
+		user, password, _ := req.BasicAuth()
+		authorized = user == r.username && password == r.password
+		w.Header().Set("WWW-Authenticate", `Basic realm="test-registry"`)
+	}
+	if !authorized {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	data, ok := r.content[req.URL.Path]
+	if !ok {
+		http.NotFound(w, req)
+		return
+	}
+	w.Write(data)
+}
+
+// host returns the registry's host, the prefix of references to its images.
+func (r *testRegistry) host() string {
+	return strings.TrimPrefix(r.URL, "http://")
+}
+
+// blob stores data as a blob of repo.
+func (r *testRegistry) blob(repo string, data []byte) ociDescriptor {
+	digest := sha256Digest(data)
+	r.content["/v2/"+repo+"/blobs/"+digest] = data
+	return ociDescriptor{Digest: digest, Size: int64(len(data))}
+}
+
+// manifest stores manifest in repo under its digest and, if set, tag.
+func (r *testRegistry) manifest(repo, tag, mediaType string, manifest map[string]interface{}) ociDescriptor {
+	manifest["schemaVersion"] = 2
+	manifest["mediaType"] = mediaType
+	data, _ := json.Marshal(manifest)
+	digest := sha256Digest(data)
+	r.content["/v2/"+repo+"/manifests/"+digest] = data
+	if tag != "" {
+		r.content["/v2/"+repo+"/manifests/"+tag] = data
+	}
+	return ociDescriptor{MediaType: mediaType, Digest: digest, Size: int64(len(data))}
+}
+
+// image stores an image of repo with config and one layer blob of each size, and returns
+// the descriptors of its manifest and layers.
+func (r *testRegistry) image(repo, tag, mediaType string, config imageConfig, sizes ...int64) (ociDescriptor, []ociDescriptor) {
+	data, _ := json.Marshal(config)
+	var layers []ociDescriptor
+	for i, size := range sizes {
+		layers = append(layers, ociDescriptor{
+			MediaType: "application/vnd.oci.image.layer.v1.tar+gzip",
+			Digest:    sha256Digest([]byte(fmt.Sprintf("%s/%s/%s/%d", repo, config.OS, config.Architecture, i))),
+			Size:      size,
+		})
+	}
+	manifest := r.manifest(repo, tag, mediaType, map[string]interface{}{
+		"config": r.blob(repo, data),
+		"layers": layers,
+	})
+	return manifest, layers
+}
+
+// appConfig is the config of a two-layer image for platform, with an ENV in between.
+func appConfig(os, arch, variant string) imageConfig {
+	return imageConfig{OS: os, Architecture: arch, Variant: variant, History: []configHistory{
+		{CreatedBy: "ADD rootfs.tar /"},
+		{CreatedBy: "ENV PATH=/usr/local/bin:/usr/bin", EmptyLayer: true},
+		{CreatedBy: "RUN make install"},
+	}}
+}
+
+func TestParseReference(t *testing.T) {
+	for _, tc := range []struct {
+		ref                             string
+		registry, repository, reference string
+	}{
+		{"ubuntu", dockerHubRegistry, "library/ubuntu", "latest"},
+		{"ubuntu:22.04", dockerHubRegistry, "library/ubuntu", "22.04"},
+		{"docker.io/library/ubuntu:22.04", dockerHubRegistry, "library/ubuntu", "22.04"},
+		{"docker.io/ubuntu", dockerHubRegistry, "library/ubuntu", "latest"},
+		{"index.docker.io/team/app:1", dockerHubRegistry, "team/app", "1"},
+		{"team/app:1", dockerHubRegistry, "team/app", "1"},
+		{"localhost/app", "localhost", "app", "latest"},
+		{"localhost:5000/app", "localhost:5000", "app", "latest"},
+		{"localhost:5000/team/app:1.4", "localhost:5000", "team/app", "1.4"},
+		{"ghcr.io/org/team/app:1.0", "ghcr.io", "org/team/app", "1.0"},
+		{"app@sha256:abc", dockerHubRegistry, "library/app", "sha256:abc"},
+		{"app:1.4@sha256:abc", dockerHubRegistry, "library/app", "sha256:abc"},
+		{"localhost:5000/app:1.4@sha256:abc", "localhost:5000", "app", "sha256:abc"},
+	} {
+		got, err := parseReference(tc.ref)
+		if err != nil {
+			t.Errorf("parseReference(%q): %v", tc.ref, err)
+			continue
+		}
+		want := imageReference{Registry: tc.registry, Repository: tc.repository, Reference: tc.reference}
+		if got != want {
+			t.Errorf("parseReference(%q) = %+v, want %+v", tc.ref, got, want)
+		}
+	}
+
+	for _, ref := range []string{"", ":1.4", "@sha256:abc", "ghcr.io/"} {
+		if got, err := parseReference(ref); err == nil {
+			t.Errorf("parseReference(%q) = %+v, want an error", ref, got)
+		}
+	}
+}
+
+func TestAuthChallenge(t *testing.T) {
+	for _, tc := range []struct {
+		header string
+		scheme string
+		params map[string]string
+	}{
+		{
+			`Bearer realm="https://auth.docker.io/token",service="registry.docker.io",scope="repository:library/ubuntu:pull"`,
+			"bearer",
+			map[string]string{"realm": "https://auth.docker.io/token", "service": "registry.docker.io", "scope": "repository:library/ubuntu:pull"},
+		},
+		{
+			`bearer Realm=https://ghcr.io/token, Service=ghcr.io`,
+			"bearer",
+			map[string]string{"realm": "https://ghcr.io/token", "service": "ghcr.io"},
+		},
+		{
+			`Bearer realm="https://auth.example.com/token",scope="repository:app:pull,push"`,
+			"bearer",
+			map[string]string{"realm": "https://auth.example.com/token", "scope": "repository:app:pull,push"},
+		},
+		{`Basic realm="Registry Realm"`, "basic", map[string]string{"realm": "Registry Realm"}},
+		{"", "", map[string]string{}},
+	} {
+		scheme, params := authChallenge(tc.header)
+		if scheme != tc.scheme || fmt.Sprint(params) != fmt.Sprint(tc.params) {
+			t.Errorf("authChallenge(%q) = %q, %v, want %q, %v", tc.header, scheme, params, tc.scheme, tc.params)
+		}
+	}
+}
+
+func TestNewDockerImageFromRegistry(t *testing.T) {
+	for _, mediaType := range []string{dockerManifestType, ociManifestType} {
+		t.Run(mediaType, func(t *testing.T) {
+			registry := newTestRegistry(t)
+			manifest, layers := registry.image("team/app", "1.4", mediaType, appConfig("linux", "amd64", ""), 3000, 200)
+
+			for _, ref := range []string{registry.host() + "/team/app:1.4", registry.host() + "/team/app@" + manifest.Digest} {
+				image, err := NewDockerImageFromRegistry(context.Background(), ref, RegistryOptions{Insecure: true})
+				if err != nil {
+					t.Fatal(err)
+				}
+				if image.Name != ref || image.Size != 3200 {
+					t.Errorf("got image %q of %d bytes, want %q of 3200 bytes", image.Name, image.Size, ref)
+				}
+				if len(image.Layers) != 3 {
+					t.Fatalf("got %d layers, want 3", len(image.Layers))
+				}
+				for i, want := range []DockerLayer{
+					{ID: layers[0].Digest, Size: 3000, Command: "ADD"},
+					{ID: "<missing>", Size: 0, Command: "ENV"},
+					{ID: layers[1].Digest, Size: 200, Command: "RUN"},
+				} {
+					if got := image.Layers[i]; got.ID != want.ID || got.Size != want.Size || got.Command != want.Command {
+						t.Errorf("layer %d: got %s %s of %d bytes, want %s %s of %d bytes", i, got.Command, got.ID, got.Size, want.Command, want.ID, want.Size)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestNewDockerImageFromRegistryAuth(t *testing.T) {
+	for _, tc := range []struct {
+		name          string
+		token         string
+		opts          RegistryOptions
+		wantErr       string
+		tokenRequests int32
+	}{
+		{"token", "t0ken", RegistryOptions{Username: "ci", Password: "pw"}, "", 1},
+		{"token with wrong password", "t0ken", RegistryOptions{Username: "ci", Password: "nope"}, "failed to authenticate", 1},
+		{"preset token", "t0ken", RegistryOptions{Token: "t0ken"}, "", 0},
+		{"basic", "", RegistryOptions{Username: "ci", Password: "pw"}, "", 0},
+		{"basic without credentials", "", RegistryOptions{}, "401 Unauthorized", 0},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			registry := newTestRegistry(t)
+			registry.token, registry.username, registry.password = tc.token, "ci", "pw"
+			registry.image("app", "1", ociManifestType, appConfig("linux", "amd64", ""), 100, 100)
+
+			tc.opts.Insecure = true
+			image, err := NewDockerImageFromRegistry(context.Background(), registry.host()+"/app:1", tc.opts)
+			switch {
+			case tc.wantErr == "" && err != nil:
+				t.Fatal(err)
+			case tc.wantErr == "" && len(image.Layers) != 3:
+				t.Errorf("got %d layers, want 3", len(image.Layers))
+			case tc.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tc.wantErr)):
+				t.Errorf("got error %v, want one containing %q", err, tc.wantErr)
+			}
+			if n := atomic.LoadInt32(&registry.tokenRequests); n != tc.tokenRequests {
+				t.Errorf("requested a token %d times, want %d", n, tc.tokenRequests)
+			}
+		})
+	}
+}
+
+func TestNewDockerImageFromRegistryErrors(t *testing.T) {
+	registry := newTestRegistry(t)
+	manifest, _ := registry.image("app", "1", ociManifestType, appConfig("linux", "amd64", ""), 100)
+	var parsed ociManifest
+	json.Unmarshal(registry.content["/v2/app/manifests/1"], &parsed)
+	registry.content["/v2/app/blobs/"+parsed.Config.Digest] = []byte(`{"os":"linux","architecture":"arm64"}`)
+	registry.content["/v2/app/manifests/"+manifest.Digest] = []byte(`{"schemaVersion":2}`)
+	registry.manifest("multi", "1", ociIndexType, map[string]interface{}{"manifests": []ociDescriptor{
+		{MediaType: ociManifestType, Digest: "sha256:aaa", Platform: &ociPlatform{OS: "linux", Architecture: "amd64"}},
+		{MediaType: ociManifestType, Digest: "sha256:bbb", Platform: &ociPlatform{OS: "linux", Architecture: "arm64", Variant: "v8"}},
+	}})
+
+	for _, tc := range []struct {
+		name     string
+		ref      string
+		wantErr  string
+		notFound bool
+	}{
+		{"missing tag", "app:2", "manifests/2", true},
+		{"missing repository", "other:1", "manifests/1", true},
+		{"config digest mismatch", "app:1", "digest mismatch for " + parsed.Config.Digest, false},
+		{"manifest digest mismatch", "app@" + manifest.Digest, "digest mismatch for " + manifest.Digest, false},
+		{"manifest list", "multi:1", "is a manifest list; specify a platform (available: linux/amd64, linux/arm64/v8)", false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := NewDockerImageFromRegistry(context.Background(), registry.host()+"/"+tc.ref, RegistryOptions{Insecure: true})
+			if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Fatalf("got error %v, want one containing %q", err, tc.wantErr)
+			}
+			if got := errors.Is(err, ErrImageNotFound); got != tc.notFound {
+				t.Errorf("errors.Is(%v, ErrImageNotFound) = %t, want %t", err, got, tc.notFound)
+			}
+		})
+	}
+}
+
+// multiArchImage stores "multi:1", a manifest list with images for linux/amd64, linux/arm64/v8
+// and linux/arm/v7 and an attestation manifest, and "single:1", a linux/amd64 image. It returns
+// the platforms expected from ListPlatforms for the manifest list.
+func multiArchImage(registry *testRegistry) []PlatformImage {
+	var entries []ociDescriptor
+	var platforms []PlatformImage
+	for _, image := range []struct {
+		platform ociPlatform
+		sizes    []int64
+	}{
+		{ociPlatform{OS: "linux", Architecture: "amd64"}, []int64{3000, 200}},
+		{ociPlatform{OS: "linux", Architecture: "arm64", Variant: "v8"}, []int64{2800, 250}},
+		{ociPlatform{OS: "linux", Architecture: "arm", Variant: "v7"}, []int64{2500, 180}},
+	} {
+		config := appConfig(image.platform.OS, image.platform.Architecture, image.platform.Variant)
+		manifest, _ := registry.image("multi", "", ociManifestType, config, image.sizes...)
+		platform := image.platform
+		manifest.Platform = &platform
+		entries = append(entries, manifest)
+
+		data, _ := json.Marshal(config)
+		platforms = append(platforms, PlatformImage{
+			OS:           platform.OS,
+			Architecture: platform.Architecture,
+			Variant:      platform.Variant,
+			Digest:       manifest.Digest,
+			Size:         int64(len(data)) + image.sizes[0] + image.sizes[1],
+		})
+	}
+	attestation := registry.manifest("multi", "", ociManifestType, map[string]interface{}{
+		"config": registry.blob("multi", []byte(`{}`)),
+	})
+	attestation.Platform = &ociPlatform{OS: "unknown", Architecture: "unknown"}
+	entries = append(entries, attestation)
+	registry.manifest("multi", "1", ociIndexType, map[string]interface{}{"manifests": entries})
+
+	registry.image("single", "1", dockerManifestType, appConfig("linux", "amd64", ""), 3000, 200)
+	return platforms
+}
+
+func TestNewDockerImageFromRegistryPlatform(t *testing.T) {
+	registry := newTestRegistry(t)
+	multiArchImage(registry)
+
+	for _, tc := range []struct {
+		ref      string
+		platform string
+		wantSize int64
+		wantErr  string
+	}{
+		{"multi:1", "linux/amd64", 3200, ""},
+		{"multi:1", "linux/arm64/v8", 3050, ""},
+		{"multi:1", "linux/arm64", 3050, ""}, // no variant matches any variant
+		{"multi:1", "linux/arm/v7", 2680, ""},
+		{"multi:1", "linux/arm/v6", 0, "has no image for linux/arm/v6 (available: linux/amd64, linux/arm64/v8, linux/arm/v7)"},
+		{"multi:1", "unknown/unknown", 0, "has no image for unknown/unknown"},
+		{"multi:1", "linux", 0, `invalid platform "linux"`},
+		{"multi:1", "", 0, "is a manifest list; specify a platform (available: linux/amd64, linux/arm64/v8, linux/arm/v7)"},
+		{"single:1", "", 3200, ""},
+		{"single:1", "linux/amd64", 3200, ""},
+		{"single:1", "linux/arm64", 0, "is a single linux/amd64 image, not linux/arm64"},
+	} {
+		t.Run(tc.ref+" "+tc.platform, func(t *testing.T) {
+			image, err := NewDockerImageFromRegistry(context.Background(), registry.host()+"/"+tc.ref, RegistryOptions{Insecure: true, Platform: tc.platform})
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Errorf("got error %v, want one containing %q", err, tc.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if image.Size != tc.wantSize {
+				t.Errorf("got an image of %d bytes, want %d", image.Size, tc.wantSize)
+			}
+		})
+	}
+}
+
+func TestListPlatforms(t *testing.T) {
+	registry := newTestRegistry(t)
+	want := multiArchImage(registry)
+	opts := RegistryOptions{Insecure: true}
+
+	got, err := ListPlatforms(context.Background(), registry.host()+"/multi:1", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("ListPlatforms(multi:1) = %+v, want %+v", got, want)
+	}
+	if got[1].Platform() != "linux/arm64/v8" {
+		t.Errorf("Platform() = %q, want linux/arm64/v8", got[1].Platform())
+	}
+
+	single, err := ListPlatforms(context.Background(), registry.host()+"/single:1", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(single) != 1 || single[0].Platform() != "linux/amd64" || single[0].Size != want[0].Size {
+		t.Errorf("ListPlatforms(single:1) = %+v, want one linux/amd64 image of %d bytes", single, want[0].Size)
+	}
+	if digest := sha256Digest(registry.content["/v2/single/manifests/1"]); single[0].Digest != digest {
+		t.Errorf("Digest = %q, want %q", single[0].Digest, digest)
+	}
+
+	if _, err := ListPlatforms(context.Background(), registry.host()+"/multi:2", opts); !errors.Is(err, ErrImageNotFound) {
+		t.Errorf("got error %v, want ErrImageNotFound", err)
+	}
+}
+
+func TestComparePlatforms(t *testing.T) {
+	registry := newTestRegistry(t)
+	multiArchImage(registry)
+	ref := registry.host() + "/multi:1"
+	opts := RegistryOptions{Insecure: true}
+
+	diff, err := ComparePlatforms(context.Background(), ref, "linux/amd64", "linux/arm64", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff.SizeDelta != -150 {
+		t.Errorf("SizeDelta = %d, want -150", diff.SizeDelta)
+	}
+	want := []LayerSizeDelta{
+		{CreatedBy: "ADD rootfs.tar /", OldSize: 3000, NewSize: 2800},
+		{CreatedBy: "RUN make install", OldSize: 200, NewSize: 250},
+	}
+	if fmt.Sprint(diff.LayerDeltas) != fmt.Sprint(want) {
+		t.Errorf("LayerDeltas = %+v, want %+v", diff.LayerDeltas, want)
+	}
+
+	if _, err := ComparePlatforms(context.Background(), ref, "linux/amd64", "windows/amd64", opts); err == nil || !strings.Contains(err.Error(), "windows/amd64") {
+		t.Errorf("got error %v, want one naming the missing platform", err)
+	}
+}
@@ -0,0 +1,156 @@
+package analysis
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// SecretPattern is a named regular expression that finds secrets in layer commands. If the
+// expression has capture groups, the last one holds the secret and the rest of the match is
+// context; otherwise the whole match is the secret. A match where the last group is optional
+// and didn't match holds no secret of its own and is reported as is.
+type SecretPattern struct {
+	Name     string
+	Regexp   *regexp.Regexp
+	Severity Severity
+}
+
+// SecretFinding is a secret found in the CreatedBy of a layer. Excerpt is the matched text
+// with the secret redacted.
+type SecretFinding struct {
+	LayerID  string
+	Pattern  string
+	Severity Severity
+	Excerpt  string
+}
+
+// String returns the finding as "severity [pattern] layer: excerpt".
+func (f SecretFinding) String() string {
+	return fmt.Sprintf("%s [%s] %s: %s", f.Severity, f.Pattern, shortID(f.LayerID), f.Excerpt)
+}
+
+// builtinSecretPatterns are checked in order, the specific ones first, so that a secret matched
+// by several patterns is reported under the most specific one.
+var builtinSecretPatterns = []SecretPattern{
+	{"aws-access-key-id", regexp.MustCompile(`\b(?:AKIA|ASIA)[0-9A-Z]{16}\b`), SeverityError},
+	{"github-token", regexp.MustCompile(`\b(?:gh[pousr]_[A-Za-z0-9]{36,}|github_pat_[A-Za-z0-9_]{22,})\b`), SeverityError},
+	{"private-key", regexp.MustCompile(`-----BEGIN (?:[A-Z]+ )*PRIVATE KEY-----((?:\\n|\s)*[A-Za-z0-9+/=][A-Za-z0-9+/=\\\s]*)?`), SeverityError},
+	{"basic-auth-url", regexp.MustCompile(`\b[a-z][a-z0-9+.-]*://[^\s/:@]+:([^\s/@"']+)@`), SeverityError},
+	{"curl-user", regexp.MustCompile(`(?:\s-u|--user)[\s=]+["']?[^\s:"']+:([^\s"']+)`), SeverityError},
+	{"secret-assignment", secretAssignment, SeverityWarning},
+}
+
+var (
+	secretPatternsMu   sync.Mutex
+	customSecrets      []SecretPattern
+	allowedSecretTexts []string
+)
+
+// RegisterSecretPattern adds a pattern that ScanForSecrets checks after the built-in ones.
+// It returns an error if pattern isn't a valid regular expression.
+func RegisterSecretPattern(name, pattern string, severity Severity) error {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return fmt.Errorf("invalid secret pattern %s: %w", name, err)
+	}
+	secretPatternsMu.Lock()
+	defer secretPatternsMu.Unlock()
+	customSecrets = append(customSecrets, SecretPattern{Name: name, Regexp: re, Severity: severity})
+	return nil
+}
+
+// AllowSecrets marks strings as known false positives: matches containing any of them aren't reported.
+func AllowSecrets(texts ...string) {
+	secretPatternsMu.Lock()
+	defer secretPatternsMu.Unlock()
+	allowedSecretTexts = append(allowedSecretTexts, texts...)
+}
+
+// secretPatterns returns the built-in and registered patterns and the allowlist.
+func secretPatterns() ([]SecretPattern, []string) {
+	secretPatternsMu.Lock()
+	defer secretPatternsMu.Unlock()
+	patterns := append(append([]SecretPattern(nil), builtinSecretPatterns...), customSecrets...)
+	return patterns, append([]string(nil), allowedSecretTexts...)
+}
+
+// redactSecret returns the match at loc in s with its secret replaced by "****". A secret that
+// is the whole match keeps its first four characters, which for keys and tokens is a public prefix.
+// A match whose secret group didn't match, like a private key header without the key, is
+// returned unredacted and doubles as the secret.
+func redactSecret(s string, loc []int) (excerpt, secret string) {
+	start, end := loc[0], loc[1]
+	if n := len(loc); n > 2 && loc[n-2] >= 0 {
+		secret = s[loc[n-2]:loc[n-1]]
+		return strings.TrimSpace(s[start:loc[n-2]] + "****" + s[loc[n-1]:end]), secret
+	} else if n > 2 {
+		match := s[start:end]
+		return strings.TrimSpace(match), match
+	}
+	secret = s[start:end]
+	if len(secret) > 8 {
+		return secret[:4] + "****", secret
+	}
+	return "****", secret
+}
+
+// ScanForSecrets checks the CreatedBy of each layer against the built-in secret patterns (AWS
+// access keys, GitHub tokens, private keys, credentials in URLs and curl commands, and
+// assignments to variables named like secrets) and those added with RegisterSecretPattern.
+// Values referring to another variable, such as TOKEN=$CI_TOKEN, and matches containing a
+// string passed to AllowSecrets are skipped. A secret is reported once per layer.
+func ScanForSecrets(layers []DockerLayer) []SecretFinding {
+	patterns, allowed := secretPatterns()
+
+	var findings []SecretFinding
+	for _, layer := range layers {
+		seen := make(map[string]bool)
+		for _, pattern := range patterns {
+			for _, loc := range pattern.Regexp.FindAllStringSubmatchIndex(layer.CreatedBy, -1) {
+				excerpt, secret := redactSecret(layer.CreatedBy, loc)
+				if secret == "" || strings.HasPrefix(secret, "$") || seen[secret] {
+					continue
+				}
+				if isAllowedSecret(layer.CreatedBy[loc[0]:loc[1]], allowed) {
+					continue
+				}
+				seen[secret] = true
+				findings = append(findings, SecretFinding{
+					LayerID:  layer.ID,
+					Pattern:  pattern.Name,
+					Severity: pattern.Severity,
+					Excerpt:  excerpt,
+				})
+			}
+		}
+	}
+	return findings
+}
+
+// isAllowedSecret reports whether match contains one of the allowed strings.
+func isAllowedSecret(match string, allowed []string) bool {
+	for _, text := range allowed {
+		if text != "" && strings.Contains(match, text) {
+			return true
+		}
+	}
+	return false
+}
+
+// SecretScanRule reports the findings of ScanForSecrets as lint findings.
+func SecretScanRule() Rule {
+	return RuleFunc(func(image *DockerImage) []Finding {
+		var findings []Finding
+		for _, secret := range ScanForSecrets(image.Layers) {
+			findings = append(findings, Finding{
+				Rule:     "secret-scan",
+				Severity: secret.Severity,
+				LayerID:  secret.LayerID,
+				Message:  fmt.Sprintf("%s: %s", secret.Pattern, secret.Excerpt),
+			})
+		}
+		return findings
+	})
+}
@@ -0,0 +1,150 @@
+package analysis
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// layerJSON is the serialized form of a DockerLayer. The parent is stored by ID to avoid
+// embedding the whole parent chain in every layer.
+type layerJSON struct {
+	ID        string    `json:"id"`
+	Size      int64     `json:"size"`
+	Command   string    `json:"command"`
+	Author    string    `json:"author"`
+	Created   time.Time `json:"created"`
+	CreatedBy string    `json:"createdBy"`
+	Comment   string    `json:"comment,omitempty"`
+	Tags      []string  `json:"tags"`
+	ParentID  string    `json:"parentId,omitempty"`
+}
+
+// imageJSON is the serialized form of a DockerImage.
+type imageJSON struct {
+	Name   string      `json:"name"`
+	Size   int64       `json:"size"`
+	Layers []layerJSON `json:"layers"`
+}
+
+// toJSON converts the image to its serialized form.
+func (image DockerImage) toJSON() imageJSON {
+	out := imageJSON{
+		Name:   image.Name,
+		Size:   image.Size,
+		Layers: make([]layerJSON, 0, len(image.Layers)),
+	}
+	for _, layer := range image.Layers {
+		encoded := layerJSON{
+			ID:        layer.ID,
+			Size:      layer.Size,
+			Command:   layer.Command,
+			Author:    layer.Author,
+			Created:   layer.Created,
+			CreatedBy: layer.CreatedBy,
+			Comment:   layer.Comment,
+			Tags:      layer.Tags,
+		}
+		if layer.Parent != nil {
+			encoded.ParentID = layer.Parent.ID
+		}
+		out.Layers = append(out.Layers, encoded)
+	}
+	return out
+}
+
+// MarshalJSON encodes the image with its layers in order, storing each layer's parent by ID.
+func (image DockerImage) MarshalJSON() ([]byte, error) {
+	return json.Marshal(image.toJSON())
+}
+
+// UnmarshalJSON decodes an image written by MarshalJSON and reconnects each layer to its parent.
+// A parent ID is resolved to the nearest earlier layer with that ID, so chains of layers sharing
+// the "<missing>" ID are restored in order. Parents that aren't part of the image are left nil.
+func (image *DockerImage) UnmarshalJSON(data []byte) error {
+	var in imageJSON
+	if err := json.Unmarshal(data, &in); err != nil {
+		return err
+	}
+
+	layers := make([]DockerLayer, len(in.Layers))
+	for i, decoded := range in.Layers {
+		layers[i] = DockerLayer{
+			ID:        decoded.ID,
+			Size:      decoded.Size,
+			Command:   decoded.Command,
+			Author:    decoded.Author,
+			Created:   decoded.Created,
+			CreatedBy: decoded.CreatedBy,
+			Comment:   decoded.Comment,
+			Tags:      decoded.Tags,
+		}
+		if decoded.ParentID == "" {
+			continue
+		}
+		for j := i - 1; j >= 0; j-- {
+			if layers[j].ID == decoded.ParentID {
+				layers[i].Parent = &layers[j]
+				break
+			}
+		}
+	}
+
+	*image = DockerImage{
+		Name:   in.Name,
+		Layers: layers,
+		Size:   in.Size,
+	}
+	return nil
+}
+
+// reportStats holds the statistics included in an image report.
+type reportStats struct {
+	LayerCount    int      `json:"layerCount"`
+	TotalSize     int64    `json:"totalSize"`
+	AverageSize   float64  `json:"averageSize"`
+	MedianSize    int64    `json:"medianSize"`
+	UniqueAuthors []string `json:"uniqueAuthors"`
+}
+
+// imageReport is the serialized form of an image along with its statistics.
+type imageReport struct {
+	imageJSON
+	Stats reportStats `json:"stats"`
+}
+
+// MarshalReport encodes the image, its layers and summary statistics in format, which must be
+// "json". The result can be read back with UnmarshalImage.
+func (image *DockerImage) MarshalReport(format string) ([]byte, error) {
+	authors := image.UniqueAuthors()
+	sort.Strings(authors)
+	report := imageReport{
+		imageJSON: image.toJSON(),
+		Stats: reportStats{
+			LayerCount:    len(image.Layers),
+			TotalSize:     TotalSize(image.Layers),
+			AverageSize:   AverageSize(image.Layers),
+			MedianSize:    MedianSize(image.Layers),
+			UniqueAuthors: authors,
+		},
+	}
+
+	switch strings.ToLower(format) {
+	case "json":
+		return json.MarshalIndent(report, "", "  ")
+	default:
+		return nil, fmt.Errorf("unsupported report format: %s", format)
+	}
+}
+
+// UnmarshalImage rebuilds an image from a report written by MarshalReport or from the output of
+// MarshalJSON. Parent links are restored from the layers' parent IDs.
+func UnmarshalImage(data []byte) (*DockerImage, error) {
+	var image DockerImage
+	if err := json.Unmarshal(data, &image); err != nil {
+		return nil, fmt.Errorf("failed to parse image: %w", err)
+	}
+	return &image, nil
+}
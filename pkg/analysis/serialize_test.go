@@ -0,0 +1,48 @@
+package analysis
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMarshalReportRoundTrip(t *testing.T) {
+	created := time.Date(2023, 6, 14, 9, 12, 40, 0, time.UTC)
+	layers := []DockerLayer{
+		{ID: "<missing>", Size: 74760192, Command: "ADD", Created: created, CreatedBy: "/bin/sh -c #(nop) ADD file:abc in / "},
+		{ID: "<missing>", Command: "CMD", Created: created, CreatedBy: `/bin/sh -c #(nop)  CMD ["bash"]`, Tags: []string{}},
+		{ID: "sha256:top", Size: 1024, Command: "RUN", Author: "ops <ops@example.com>", Created: created,
+			CreatedBy: "RUN echo 'a: b' # buildkit\n\tand more", Comment: "buildkit.dockerfile.v0", Tags: []string{"app:1.4", "app:latest"}},
+	}
+	linkLayers(layers)
+	image := &DockerImage{Name: "app:1.4", Layers: layers, Size: 74761216}
+
+	data, err := image.MarshalReport("json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := UnmarshalImage(data)
+	if err != nil {
+		t.Fatalf("UnmarshalImage: %v\n%s", err, data)
+	}
+	if got.Name != image.Name || got.Size != image.Size || len(got.Layers) != len(image.Layers) {
+		t.Fatalf("got %+v", got)
+	}
+	for i, layer := range got.Layers {
+		want := image.Layers[i]
+		if layer.ID != want.ID || layer.Size != want.Size || layer.CreatedBy != want.CreatedBy ||
+			layer.Author != want.Author || !layer.Created.Equal(want.Created) || len(layer.Tags) != len(want.Tags) {
+			t.Errorf("layer %d: got %+v, want %+v", i, layer, want)
+		}
+		if i == 0 && layer.Parent != nil || i > 0 && layer.Parent != &got.Layers[i-1] {
+			t.Errorf("layer %d: wrong parent", i)
+		}
+	}
+}
+
+func TestMarshalReportUnsupportedFormat(t *testing.T) {
+	for _, format := range []string{"yaml", "xml", ""} {
+		if _, err := (&DockerImage{}).MarshalReport(format); err == nil {
+			t.Errorf("MarshalReport(%q): got no error", format)
+		}
+	}
+}
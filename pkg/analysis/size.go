@@ -0,0 +1,102 @@
+package analysis
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// sizeUnits maps the unit suffixes docker prints (and their binary counterparts) to a number of bytes.
+var sizeUnits = map[string]float64{
+	"":    1,
+	"b":   1,
+	"kb":  1e3,
+	"mb":  1e6,
+	"gb":  1e9,
+	"tb":  1e12,
+	"pb":  1e15,
+	"kib": 1 << 10,
+	"mib": 1 << 20,
+	"gib": 1 << 30,
+	"tib": 1 << 40,
+	"pib": 1 << 50,
+}
+
+// ParseSize parses a size printed by docker, either a plain number of bytes or a
+// human-readable value like "0B", "12.3MB", "1.04GB" or "345kB". Units are case-insensitive;
+// kB, MB and GB are decimal and KiB, MiB and GiB binary, as in docker's output.
+// Negative sizes and sizes that don't fit in an int64 are errors.
+func ParseSize(s string) (int64, error) {
+	s = strings.TrimSpace(s)
+	if strings.HasPrefix(s, "-") {
+		return 0, fmt.Errorf("invalid size %q: negative", s)
+	}
+	if size, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return size, nil
+	}
+
+	i := strings.IndexFunc(s, func(r rune) bool {
+		return (r < '0' || r > '9') && r != '.'
+	})
+	if i < 0 {
+		i = len(s)
+	}
+	number, unit := s[:i], strings.ToLower(strings.TrimSpace(s[i:]))
+
+	multiplier, ok := sizeUnits[unit]
+	if !ok {
+		return 0, fmt.Errorf("invalid size %q: unknown unit %q", s, unit)
+	}
+	value, err := strconv.ParseFloat(number, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid size %q: %w", s, err)
+	}
+	// float64(math.MaxInt64) rounds up to 2^63, so a product equal to it doesn't fit either.
+	if value >= float64(math.MaxInt64)/multiplier {
+		return 0, fmt.Errorf("invalid size %q: out of range", s)
+	}
+	return int64(math.Round(value * multiplier)), nil
+}
+
+var (
+	binaryUnits  = []string{"B", "KB", "MB", "GB", "TB", "PB", "EB"}
+	decimalUnits = []string{"B", "kB", "MB", "GB", "TB", "PB", "EB"}
+)
+
+// formatSize formats bytes with one decimal in the largest unit of the given base that keeps the value at least 1.
+func formatSize(bytes int64, base float64, units []string) string {
+	if bytes < 0 {
+		bytes = 0
+	}
+	if float64(bytes) < base {
+		return fmt.Sprintf("%d %s", bytes, units[0])
+	}
+
+	value := float64(bytes)
+	unit := 0
+	// Compare the rounded value so 1023.96 KB is shown as 1.0 MB rather than 1024.0 KB.
+	for math.Round(value*10)/10 >= base && unit < len(units)-1 {
+		value /= base
+		unit++
+	}
+	return fmt.Sprintf("%.1f %s", value, units[unit])
+}
+
+// HumanSize formats a number of bytes using binary (1024-based) units with one decimal, e.g. "175.2 MB".
+// Negative sizes are treated as zero.
+func HumanSize(bytes int64) string {
+	return formatSize(bytes, 1024, binaryUnits)
+}
+
+// FormatSize formats a number of bytes the way docker prints sizes, e.g. "1.2 kB", "3.4 MB" or "5.6 GB".
+// It is the same as HumanSizeSI.
+func FormatSize(bytes int64) string {
+	return HumanSizeSI(bytes)
+}
+
+// HumanSizeSI formats a number of bytes using decimal (1000-based) units with one decimal, e.g. "183.7 MB",
+// as docker does in most of its output. Negative sizes are treated as zero.
+func HumanSizeSI(bytes int64) string {
+	return formatSize(bytes, 1000, decimalUnits)
+}
@@ -0,0 +1,42 @@
+package analysis
+
+import "testing"
+
+func TestParseSize(t *testing.T) {
+	for _, tc := range []struct {
+		in   string
+		want int64
+	}{
+		{"0", 0},
+		{"0B", 0},
+		{" 1234 ", 1234},
+		{"345kB", 345000},
+		{"12.3MB", 12300000},
+		{"1.04GB", 1040000000},
+		{"2KiB", 2048},
+		{"1.5 MiB", 1572864},
+		{"9223372036854775807", 9223372036854775807},
+	} {
+		got, err := ParseSize(tc.in)
+		if err != nil || got != tc.want {
+			t.Errorf("ParseSize(%q) = %d, %v; want %d", tc.in, got, err, tc.want)
+		}
+	}
+
+	for _, in := range []string{
+		"",
+		"MB",
+		"-5",
+		"-1.5MB",
+		"12 parsecs",
+		"9223372036854775808",
+		"99999999999999999999",
+		"9300PB",
+		"8192PiB",
+		"1.2.3MB",
+	} {
+		if got, err := ParseSize(in); err == nil {
+			t.Errorf("ParseSize(%q) = %d, want an error", in, got)
+		}
+	}
+}
@@ -0,0 +1,181 @@
+package analysis
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// TreeOptions controls the output of RenderTree.
+type TreeOptions struct {
+	// Width is the maximum width of the CreatedBy column. Defaults to 80; negative means no limit.
+	Width int
+	// Indent is written once per level of depth. Defaults to two spaces.
+	Indent string
+}
+
+// layerTree indexes the parent links of an image's layers.
+type layerTree struct {
+	image    *DockerImage
+	index    map[*DockerLayer]int // position of each layer in the image
+	parents  []int                // index of each layer's parent in the image, or -1
+	children [][]int              // indexes of each layer's children, in image order
+	roots    []int
+}
+
+// newLayerTree links the layers of an image by position. A layer whose parent isn't
+// part of the image is a root. If the parent links form a cycle, its lowest index is
+// made a root as well and isn't listed among its parent's children, so that walking
+// the children from the roots visits every layer once.
+func newLayerTree(image *DockerImage) *layerTree {
+	tree := &layerTree{
+		image:    image,
+		index:    make(map[*DockerLayer]int, len(image.Layers)),
+		parents:  make([]int, len(image.Layers)),
+		children: make([][]int, len(image.Layers)),
+	}
+	for i := range image.Layers {
+		tree.index[&image.Layers[i]] = i
+	}
+	for i, layer := range image.Layers {
+		parent, ok := tree.index[layer.Parent]
+		if !ok || parent == i {
+			tree.parents[i] = -1
+			tree.roots = append(tree.roots, i)
+			continue
+		}
+		tree.parents[i] = parent
+		tree.children[parent] = append(tree.children[parent], i)
+	}
+
+	visited := make([]bool, len(image.Layers))
+	var visit func(i int)
+	visit = func(i int) {
+		visited[i] = true
+		for _, child := range tree.children[i] {
+			visit(child)
+		}
+	}
+	for _, root := range tree.roots {
+		visit(root)
+	}
+	for i := range image.Layers {
+		if visited[i] {
+			continue
+		}
+		parent := tree.parents[i]
+		for j, child := range tree.children[parent] {
+			if child == i {
+				tree.children[parent] = append(tree.children[parent][:j:j], tree.children[parent][j+1:]...)
+				break
+			}
+		}
+		tree.roots = append(tree.roots, i)
+		visit(i)
+	}
+	return tree
+}
+
+// firstLine returns the first line of a layer's CreatedBy as a normalized instruction,
+// without the backslash of a continued line.
+func firstLine(createdBy string) string {
+	line, _, _ := strings.Cut(createdBy, "\n")
+	return strings.TrimSpace(strings.TrimSuffix(normalizeInstruction(line), "\\"))
+}
+
+// RenderTree writes the layers of the image as an indented tree starting from the base layer,
+// with each layer's size, cumulative size and the first line of the instruction that created it.
+func (image *DockerImage) RenderTree(w io.Writer, opts TreeOptions) error {
+	width := opts.Width
+	if width == 0 {
+		width = 80
+	}
+	indent := opts.Indent
+	if indent == "" {
+		indent = "  "
+	}
+
+	tree := newLayerTree(image)
+	var b strings.Builder
+	var render func(i, depth int, cumulative int64)
+	render = func(i, depth int, cumulative int64) {
+		layer := image.Layers[i]
+		cumulative += layer.Size
+		instruction := firstLine(layer.CreatedBy)
+		if width > 0 {
+			instruction = truncate(instruction, width)
+		}
+		fmt.Fprintf(&b, "%s%s %s (total %s) %s\n", strings.Repeat(indent, depth),
+			shortID(layer.ID), HumanSize(layer.Size), HumanSize(cumulative), instruction)
+		for _, child := range tree.children[i] {
+			render(child, depth+1, cumulative)
+		}
+	}
+	for _, root := range tree.roots {
+		var base int64
+		// Only a parent outside the image adds to the cumulative size; one in the image is part of a cycle.
+		if parent := image.Layers[root].Parent; parent != nil {
+			if _, ok := tree.index[parent]; !ok {
+				base = parent.CumulativeSize()
+			}
+		}
+		render(root, 0, base)
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// TreeString returns the layer tree of the image as written by RenderTree with the default options.
+func (image *DockerImage) TreeString() string {
+	var b strings.Builder
+	image.RenderTree(&b, TreeOptions{})
+	return b.String()
+}
+
+var dotEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// dotString quotes s as a DOT string.
+func dotString(s string) string {
+	return `"` + dotEscaper.Replace(s) + `"`
+}
+
+// ExportDOT writes the layer hierarchy of the image as a Graphviz DOT digraph, with edges
+// pointing from parent to child. Layers whose ID is "<missing>" are named after their
+// position in the image, so the output is the same every time for the same image.
+func (image *DockerImage) ExportDOT(w io.Writer) error {
+	tree := newLayerTree(image)
+	names := make([]string, len(image.Layers))
+	for i, layer := range image.Layers {
+		if layer.ID == "<missing>" || layer.ID == "" {
+			names[i] = fmt.Sprintf("missing_%d", i)
+		} else {
+			names[i] = layer.ID
+		}
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "digraph %s {\n", dotString(image.Name))
+	fmt.Fprintf(&b, "    node [shape=box];\n")
+	declared := make(map[string]bool)
+	for i, layer := range image.Layers {
+		if declared[names[i]] {
+			continue
+		}
+		declared[names[i]] = true
+		label := shortID(layer.ID) + "\n" + HumanSize(layer.Size)
+		if keyword := instructionKeyword(layer.CreatedBy); keyword != "" {
+			label += "\n" + keyword
+		}
+		fmt.Fprintf(&b, "    %s [label=%s];\n", dotString(names[i]), dotString(label))
+	}
+	for i, parent := range tree.parents {
+		if parent >= 0 {
+			fmt.Fprintf(&b, "    %s -> %s;\n", dotString(names[parent]), dotString(names[i]))
+		}
+	}
+	fmt.Fprintf(&b, "}\n")
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
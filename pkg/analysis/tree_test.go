@@ -0,0 +1,97 @@
+package analysis
+
+import (
+	"strings"
+	"testing"
+)
+
+// treeImage returns an image of three layers, each the parent of the next.
+func treeImage() *DockerImage {
+	image := &DockerImage{Name: `team/"app"`, Layers: []DockerLayer{
+		{ID: "sha256:base0123456789", Size: 1024, CreatedBy: "/bin/sh -c #(nop) ADD file:abc in / "},
+		{ID: "<missing>", CreatedBy: "/bin/sh -c #(nop)  ENV LANG=C.UTF-8"},
+		{ID: "sha256:app00123456789", Size: 2048, CreatedBy: "RUN /bin/sh -c apt-get update \\\n && apt-get install -y curl # buildkit"},
+	}}
+	linkLayers(image.Layers)
+	return image
+}
+
+func TestRenderTree(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		opts TreeOptions
+		want string
+	}{
+		{"defaults", TreeOptions{}, "" +
+			"base01234567 1.0 KB (total 1.0 KB) ADD file:abc in /\n" +
+			"  <missing> 0 B (total 1.0 KB) ENV LANG=C.UTF-8\n" +
+			"    app001234567 2.0 KB (total 3.0 KB) RUN apt-get update\n"},
+		{"width and indent", TreeOptions{Width: 10, Indent: "| "}, "" +
+			"base01234567 1.0 KB (total 1.0 KB) ADD fil...\n" +
+			"| <missing> 0 B (total 1.0 KB) ENV LAN...\n" +
+			"| | app001234567 2.0 KB (total 3.0 KB) RUN apt...\n"},
+		{"no width limit", TreeOptions{Width: -1, Indent: "\t"}, "" +
+			"base01234567 1.0 KB (total 1.0 KB) ADD file:abc in /\n" +
+			"\t<missing> 0 B (total 1.0 KB) ENV LANG=C.UTF-8\n" +
+			"\t\tapp001234567 2.0 KB (total 3.0 KB) RUN apt-get update\n"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var b strings.Builder
+			if err := treeImage().RenderTree(&b, tc.opts); err != nil {
+				t.Fatal(err)
+			}
+			if b.String() != tc.want {
+				t.Errorf("got\n%s\nwant\n%s", b.String(), tc.want)
+			}
+		})
+	}
+}
+
+func TestRenderTreeParentCycle(t *testing.T) {
+	image := treeImage()
+	image.Layers[0].Parent = &image.Layers[2]
+
+	want := "" +
+		"base01234567 1.0 KB (total 1.0 KB) ADD file:abc in /\n" +
+		"  <missing> 0 B (total 1.0 KB) ENV LANG=C.UTF-8\n" +
+		"    app001234567 2.0 KB (total 3.0 KB) RUN apt-get update\n"
+	if got := image.TreeString(); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestExportDOT(t *testing.T) {
+	image := treeImage()
+	image.Layers[0].Parent = &image.Layers[2]
+
+	var b strings.Builder
+	if err := image.ExportDOT(&b); err != nil {
+		t.Fatal(err)
+	}
+	want := `digraph "team/\"app\"" {
+    node [shape=box];
+    "sha256:base0123456789" [label="base01234567\n1.0 KB\nADD"];
+    "missing_1" [label="<missing>\n0 B\nENV"];
+    "sha256:app00123456789" [label="app001234567\n2.0 KB\nRUN"];
+    "sha256:app00123456789" -> "sha256:base0123456789";
+    "sha256:base0123456789" -> "missing_1";
+    "missing_1" -> "sha256:app00123456789";
+}
+`
+	if b.String() != want {
+		t.Errorf("got\n%s\nwant\n%s", b.String(), want)
+	}
+}
+
+func TestDotString(t *testing.T) {
+	for _, tc := range []struct{ s, want string }{
+		{"app", `"app"`},
+		{`say "hi"`, `"say \"hi\""`},
+		{`C:\app`, `"C:\\app"`},
+		{"two\nlines", `"two\nlines"`},
+	} {
+		if got := dotString(tc.s); got != tc.want {
+			t.Errorf("dotString(%q) = %s, want %s", tc.s, got, tc.want)
+		}
+	}
+}